@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+var USAGE = "benchmark-compression [<file>...]"
+
+// loadSamples returns the loose encoding ("type size\0body") of every object
+// in the repository, or of the given files as blobs.
+func loadSamples(paths []string) ([][]byte, error) {
+	var samples [][]byte
+	encode := func(nodeType string, body []byte) {
+		sample := []byte(fmt.Sprintf("%s %d\x00", nodeType, len(body)))
+		samples = append(samples, append(sample, body...))
+	}
+	if len(paths) > 0 {
+		for _, path := range paths {
+			body, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			encode("blob", body)
+		}
+		return samples, nil
+	}
+	store := objects.GetStore()
+	err := store.Iterate(func(sha1 []byte) error {
+		nodeType, body, err := store.Read(sha1)
+		if err != nil {
+			return fmt.Errorf("%x: %w", sha1, err)
+		}
+		encode(nodeType, body)
+		return nil
+	})
+	return samples, err
+}
+
+func throughput(size int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f MB/s", float64(size)/elapsed.Seconds()/1e6)
+}
+
+// benchmark compresses and inflates every sample with one setting, checking
+// that the round trip is lossless.
+func benchmark(compression utils.Compression, samples [][]byte) (int64, time.Duration, time.Duration, error) {
+	compressedSamples := make([][]byte, len(samples))
+	var compressedSize int64
+	start := time.Now()
+	for i, sample := range samples {
+		compressed, err := compression.Compress(sample)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		compressedSamples[i] = compressed
+		compressedSize += int64(len(compressed))
+	}
+	compressTime := time.Since(start)
+	start = time.Now()
+	for i, compressed := range compressedSamples {
+		decompressed, err := utils.Decompress(compressed)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		if !bytes.Equal(decompressed, samples[i]) {
+			return 0, 0, 0, fmt.Errorf("round trip changed sample %d", i)
+		}
+	}
+	return compressedSize, compressTime, time.Since(start), nil
+}
+
+func main() {
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "-") {
+			log.Fatal(USAGE)
+		}
+	}
+	samples, err := loadSamples(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(samples) == 0 {
+		log.Fatal("nothing to benchmark")
+	}
+	var size int64
+	for _, sample := range samples {
+		size += int64(len(sample))
+	}
+	current, err := objects.LooseCompression()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d objects, %d bytes uncompressed\n", len(samples), size)
+	fmt.Printf("  %-10s %5s %12s %7s %14s %14s\n", "codec", "level", "size", "ratio", "compress", "decompress")
+	for _, preset := range utils.COMPRESSION_PRESETS {
+		compressedSize, compressTime, decompressTime, err := benchmark(preset.Compression, samples)
+		if err != nil {
+			log.Fatalf("%s: %v", preset.Name, err)
+		}
+		marker := " "
+		if preset.Compression == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %-10s %5d %12d %6.1f%% %14s %14s\n",
+			marker, preset.Name, preset.Compression.Level, compressedSize,
+			float64(compressedSize)*100/float64(size),
+			throughput(size, compressTime), throughput(size, decompressTime))
+	}
+}
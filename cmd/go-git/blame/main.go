@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/marutaku/go-git/internal/diff"
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var USAGE = "blame <commit> [--] <path>"
+
+var DATE_FORMAT = "2006-01-02 15:04:05 -0700"
+
+// origin is where a line of the final file was introduced.
+type origin struct {
+	commit []byte
+	line   int
+}
+
+// tracked follows a line of the final file back through history: line is
+// its index in the version being looked at.
+type tracked struct {
+	line  int
+	final int
+}
+
+func readLines(tree []byte, path string) ([]string, bool, error) {
+	entry, err := walk.LookupPath(tree, path)
+	if err != nil || entry == nil || entry.IsTree() || entry.IsGitlink() {
+		return nil, false, err
+	}
+	nodeType, body, err := objects.ReadSha1File(entry.Sha1)
+	if err != nil {
+		return nil, false, err
+	}
+	if nodeType != "blob" {
+		return nil, false, fmt.Errorf("%x: expected blob, got %s", entry.Sha1, nodeType)
+	}
+	return diff.SplitLines(string(body)), true, nil
+}
+
+// blame hands lines down from commit to parent for as long as the parent
+// has them too. Commits that leave path as a parent had it pass every line
+// on without a diff; the commit-graph's Bloom filters usually answer that
+// without reading any tree. Merges are followed through their first parent
+// only when no parent has the file unchanged.
+func blame(start []byte, path string) ([]string, []origin, error) {
+	commit, err := walk.LookupCommit(start)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines, ok, err := readLines(commit.Tree, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("no such path %s in %x", path, start)
+	}
+	origins := make([]origin, len(lines))
+	current := make([]tracked, len(lines))
+	for i := range lines {
+		current[i] = tracked{line: i, final: i}
+	}
+	currentLines := lines
+	for len(current) > 0 {
+		next := -1
+		for i := range commit.Parents {
+			same, err := walk.SamePaths(commit, i, []string{path})
+			if err != nil {
+				return nil, nil, err
+			}
+			if same {
+				next = i
+				break
+			}
+		}
+		if next >= 0 {
+			if commit, err = walk.LookupCommit(commit.Parents[next]); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		var parent *walk.Commit
+		var parentLines []string
+		found := false
+		if len(commit.Parents) > 0 {
+			if parent, err = walk.LookupCommit(commit.Parents[0]); err != nil {
+				return nil, nil, err
+			}
+			if parentLines, found, err = readLines(parent.Tree, path); err != nil {
+				return nil, nil, err
+			}
+		}
+		if !found {
+			for _, line := range current {
+				origins[line.final] = origin{commit: commit.Sha1, line: line.line}
+			}
+			break
+		}
+		// 親にもある行は親へ、それ以外はこのコミットで入った行
+		passed := make(map[int]int)
+		for _, match := range diff.Lines(parentLines, currentLines) {
+			passed[match.New] = match.Old
+		}
+		var remaining []tracked
+		for _, line := range current {
+			if old, ok := passed[line.line]; ok {
+				remaining = append(remaining, tracked{line: old, final: line.final})
+				continue
+			}
+			origins[line.final] = origin{commit: commit.Sha1, line: line.line}
+		}
+		current = remaining
+		currentLines = parentLines
+		commit = parent
+	}
+	return lines, origins, nil
+}
+
+func main() {
+	var names []string
+	for _, arg := range os.Args[1:] {
+		if arg != "--" {
+			names = append(names, arg)
+		}
+	}
+	if len(names) != 2 {
+		log.Fatal(USAGE)
+	}
+	sha1, err := objects.ResolveSha1Hex(names[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	start, err := object.Peel(sha1, "commit")
+	if err != nil {
+		log.Fatalf("%s: not a commit: %v", names[0], err)
+	}
+	lines, origins, err := blame(start, names[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits := map[string]*object.Commit{}
+	authorWidth := 0
+	for _, o := range origins {
+		if _, ok := commits[string(o.commit)]; ok {
+			continue
+		}
+		commit, err := object.ReadCommit(o.commit)
+		if err != nil {
+			log.Fatalf("commit %x: %v", o.commit, err)
+		}
+		commits[string(o.commit)] = commit
+		if len(commit.Author.Name) > authorWidth {
+			authorWidth = len(commit.Author.Name)
+		}
+	}
+	numberWidth := len(strconv.Itoa(len(lines)))
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for i, line := range lines {
+		commit := commits[string(origins[i].commit)]
+		fmt.Fprintf(out, "%s (%-*s %s %*d) %s\n",
+			hex.EncodeToString(origins[i].commit)[:8],
+			authorWidth, commit.Author.Name,
+			commit.Author.When.Format(DATE_FORMAT),
+			numberWidth, i+1, line)
+	}
+}
@@ -1,33 +1,169 @@
 package main
 
 import (
-	"encoding/hex"
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 
+	"github.com/marutaku/go-git/internal/object"
 	"github.com/marutaku/go-git/internal/objects"
 )
 
+var USAGE = "cat-file [-t | -s | -e | -p | <type>] <sha1> | cat-file (--batch | --batch-check) < <list>"
+
+// catType writes the body of sha1 to stdout, peeling tags until an object
+// of nodeType is found.
+func catType(nodeType string, sha1 []byte) error {
+	sha1, err := object.Peel(sha1, nodeType)
+	if err != nil {
+		return err
+	}
+	reader, err := objects.OpenSha1File(sha1)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(os.Stdout, reader)
+	return err
+}
+
+// entryType is the type of the object a tree entry points at.
+func entryType(entry *object.TreeEntry) string {
+	switch {
+	case entry.IsTree():
+		return "tree"
+	case entry.IsGitlink():
+		return "commit"
+	}
+	return "blob"
+}
+
+// prettyPrint lists trees one entry per line and writes every other object
+// as it is stored.
+func prettyPrint(sha1 []byte) error {
+	reader, err := objects.OpenSha1File(sha1)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if reader.Type != "tree" {
+		_, err = io.Copy(os.Stdout, reader)
+		return err
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	tree, err := object.ParseTree(body)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for _, entry := range tree.Entries {
+		fmt.Fprintf(out, "%06o %s %x\t%s\n", entry.Mode, entryType(entry), entry.Sha1, entry.Name)
+	}
+	return out.Flush()
+}
+
+// batch answers one object per line of stdin with "<id> <type> <size>",
+// followed by the contents and a newline when withContents is set. Records
+// are flushed one at a time so callers can interleave requests and reads.
+func batch(withContents bool) error {
+	out := bufio.NewWriter(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		name := scanner.Text()
+		sha1, err := objects.ResolveSha1Hex(name)
+		var reader *objects.ObjectReader
+		if err == nil {
+			reader, err = objects.OpenSha1File(sha1)
+		}
+		if err != nil {
+			var ambiguous *objects.AmbiguousError
+			if errors.As(err, &ambiguous) {
+				fmt.Fprintf(out, "%s ambiguous\n", name)
+			} else {
+				fmt.Fprintf(out, "%s missing\n", name)
+			}
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(out, "%x %s %d\n", sha1, reader.Type, reader.Size)
+		if withContents {
+			_, err = io.Copy(out, reader)
+			out.WriteByte('\n')
+		}
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("%x: %w", sha1, err)
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatal("cat-file: cat-file <sha1>")
+	if len(os.Args) == 2 && (os.Args[1] == "--batch" || os.Args[1] == "--batch-check") {
+		if err := batch(os.Args[1] == "--batch"); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	sha1, err := hex.DecodeString(os.Args[1])
+	if len(os.Args) != 2 && len(os.Args) != 3 {
+		log.Fatal(USAGE)
+	}
+	sha1, err := objects.ResolveSha1Hex(os.Args[len(os.Args)-1])
 	if err != nil {
-		log.Fatal("cat-file: cat-file <sha1>")
+		if len(os.Args) == 3 && os.Args[1] == "-e" {
+			os.Exit(1)
+		}
+		log.Fatal(err)
+	}
+	if len(os.Args) == 3 {
+		switch os.Args[1] {
+		case "-t", "-s":
+			nodeType, size, err := objects.ReadSha1Header(sha1)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if os.Args[1] == "-t" {
+				fmt.Println(nodeType)
+			} else {
+				fmt.Println(size)
+			}
+		case "-e":
+			if !objects.HasSha1File(sha1) {
+				os.Exit(1)
+			}
+		case "-p":
+			err = prettyPrint(sha1)
+		default:
+			err = catType(os.Args[1], sha1)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	nodeType, buf, err := objects.ReadSha1File(sha1)
+	reader, err := objects.OpenSha1File(sha1)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer reader.Close()
 	tmpfile, err := os.CreateTemp("", "temp_git_file_")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer tmpfile.Close()
-	if _, err := tmpfile.Write(buf); err != nil {
+	if _, err := io.Copy(tmpfile, reader); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s: %s\n", tmpfile.Name(), nodeType)
+	fmt.Printf("%s: %s\n", tmpfile.Name(), reader.Type)
 }
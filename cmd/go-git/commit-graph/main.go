@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/commitgraph"
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/refs"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var USAGE = "commit-graph write [--[no-]changed-paths] | commit-graph verify"
+
+// changedPathsFilter returns the Bloom filter of commit, taken from the old
+// graph when it has a usable one so that only new commits are diffed.
+func changedPathsFilter(oldGraph *commitgraph.Graph, commit *walk.Commit) (*commitgraph.BloomFilter, error) {
+	if oldGraph != nil {
+		if pos, ok := oldGraph.Find(commit.Sha1); ok {
+			if filter := oldGraph.BloomFilter(pos); filter != nil && filter.Reusable() {
+				return filter, nil
+			}
+		}
+	}
+	var parentTree []byte
+	if len(commit.Parents) > 0 {
+		parent, err := walk.LookupCommit(commit.Parents[0])
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.Tree
+	}
+	paths, err := walk.ChangedPaths(parentTree, commit.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("commit %x: %w", commit.Sha1, err)
+	}
+	return commitgraph.NewBloomFilter(paths), nil
+}
+
+// write replaces the commit-graph with one covering every commit reachable
+// from the refs. Changed-path filters are written when asked for, and kept
+// once a graph has them.
+func write(changedPaths int) error {
+	oldGraph := commitgraph.Current()
+	withFilters := changedPaths > 0 || (changedPaths == 0 && oldGraph != nil && oldGraph.HasBloomFilters())
+	var tips [][]byte
+	err := refs.ForEachRef(func(name string, sha1 []byte) error {
+		commit, err := object.Peel(sha1, "commit")
+		if err != nil {
+			// コミットを指していないrefは対象外
+			return nil
+		}
+		tips = append(tips, commit)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var entries []*commitgraph.Entry
+	err = walk.History(tips, func(commit *walk.Commit) error {
+		entry := &commitgraph.Entry{
+			Sha1:    commit.Sha1,
+			Tree:    commit.Tree,
+			Parents: commit.Parents,
+			Time:    commit.Time,
+		}
+		if withFilters {
+			filter, err := changedPathsFilter(oldGraph, commit)
+			if err != nil {
+				return err
+			}
+			entry.Bloom = filter
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return commitgraph.WriteFile(entries)
+}
+
+// verify checks the commit-graph against the commit objects it describes.
+func verify() error {
+	fileName := commitgraph.FileName()
+	graph, err := commitgraph.Read(fileName)
+	if err != nil {
+		return err
+	}
+	if err := graph.Verify(fileName); err != nil {
+		return err
+	}
+	var entries []*commitgraph.Entry
+	for pos := 0; pos < graph.Count(); pos++ {
+		sha1 := graph.Sha1(pos)
+		if pos > 0 && bytes.Compare(graph.Sha1(pos-1), sha1) >= 0 {
+			return fmt.Errorf("commit IDs out of order at %x", sha1)
+		}
+		record, err := graph.Commit(pos)
+		if err != nil {
+			return fmt.Errorf("commit %x: %w", sha1, err)
+		}
+		commit, err := object.ReadCommit(sha1)
+		if err != nil {
+			return fmt.Errorf("commit %x: %w", sha1, err)
+		}
+		if !bytes.Equal(record.Tree, commit.Tree) {
+			return fmt.Errorf("commit %x: root tree %x does not match %x", sha1, record.Tree, commit.Tree)
+		}
+		if len(record.Parents) != len(commit.Parents) {
+			return fmt.Errorf("commit %x: wrong number of parents", sha1)
+		}
+		for i, parent := range record.Parents {
+			if !bytes.Equal(graph.Sha1(parent), commit.Parents[i]) {
+				return fmt.Errorf("commit %x: parent %x does not match %x", sha1, graph.Sha1(parent), commit.Parents[i])
+			}
+		}
+		// 書き込み時と同じく範囲外の日付は0として比べる
+		if expected := commitgraph.StoredTime(commit.Committer.When.Unix()); record.Time != expected {
+			return fmt.Errorf("commit %x: commit date %d does not match %d", sha1, record.Time, expected)
+		}
+		entries = append(entries, &commitgraph.Entry{Sha1: sha1, Tree: commit.Tree, Parents: commit.Parents})
+		if filter := graph.BloomFilter(pos); filter != nil && filter.Reusable() {
+			var parentTree []byte
+			if len(commit.Parents) > 0 {
+				parent, err := object.ReadCommit(commit.Parents[0])
+				if err != nil {
+					return fmt.Errorf("commit %x: %w", commit.Parents[0], err)
+				}
+				parentTree = parent.Tree
+			}
+			paths, err := walk.ChangedPaths(parentTree, commit.Tree)
+			if err != nil {
+				return fmt.Errorf("commit %x: %w", sha1, err)
+			}
+			if !bytes.Equal(filter.Data, commitgraph.NewBloomFilter(paths).Data) {
+				return fmt.Errorf("commit %x: changed-path filter does not match", sha1)
+			}
+		}
+	}
+	generations, err := commitgraph.Generations(entries)
+	if err != nil {
+		return err
+	}
+	for pos := 0; pos < graph.Count(); pos++ {
+		record, _ := graph.Commit(pos)
+		if expected := generations[string(graph.Sha1(pos))]; record.Generation != expected {
+			return fmt.Errorf("commit %x: generation %d, expected %d", graph.Sha1(pos), record.Generation, expected)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(USAGE)
+	}
+	switch os.Args[1] {
+	case "write":
+		// 1なら作る、-1なら作らない、0なら今のグラフに合わせる
+		changedPaths := 0
+		for _, arg := range os.Args[2:] {
+			switch arg {
+			case "--changed-paths":
+				changedPaths = 1
+			case "--no-changed-paths":
+				changedPaths = -1
+			default:
+				log.Fatal(USAGE)
+			}
+		}
+		if err := write(changedPaths); err != nil {
+			log.Fatal("unable to write commit-graph: ", err)
+		}
+	case "verify":
+		if len(os.Args) != 2 {
+			log.Fatal(USAGE)
+		}
+		if err := verify(); err != nil {
+			log.Fatal("commit-graph: ", err)
+		}
+	default:
+		log.Fatal(USAGE)
+	}
+}
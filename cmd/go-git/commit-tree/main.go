@@ -2,48 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
 
 	"os/user"
 
-	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/object"
 	"github.com/marutaku/go-git/internal/objects"
 )
 
 var MAX_PARENT = 16
-var ORIG_OFFSET = 40
-
-type CommitBuffer struct {
-	buffer []byte
-	offset int
-}
-
-func newBuffer() *CommitBuffer {
-	return &CommitBuffer{
-		buffer: make([]byte, ORIG_OFFSET),
-		offset: ORIG_OFFSET,
-	}
-}
-
-func (b *CommitBuffer) addBuffer(line string) {
-	lineBytes := []byte(line)
-	if len(lineBytes) >= len(b.buffer)-b.offset {
-		appendBufferSize := (len(lineBytes) + 32767) &^ 32767
-		b.buffer = append(b.buffer, make([]byte, appendBufferSize)...)
-	}
-	copy(b.buffer[b.offset:], lineBytes)
-	b.offset += len(lineBytes)
-}
-
-func (b *CommitBuffer) finishBuffer(tag string) {
-	start := objects.PrependInteger(b.buffer, b.offset-ORIG_OFFSET, ORIG_OFFSET)
-	tagLen := len(tag)
-	start -= tagLen
-	copy(b.buffer[start:], []byte(tag))
-	b.buffer = b.buffer[start:b.offset]
-}
 
 func getParentSha1s() ([][]byte, error) {
 	// 以下のような形式で親コミットのSHA-1ハッシュ値が渡される
@@ -54,7 +24,7 @@ func getParentSha1s() ([][]byte, error) {
 		if os.Args[i] != "-p" {
 			return nil, fmt.Errorf("invalid option: %s", os.Args[i])
 		}
-		sha1Bytes, err := hash.GetSha1Hex(os.Args[i+1])
+		sha1Bytes, err := objects.ResolveSha1Hex(os.Args[i+1])
 		if err != nil {
 			return nil, err
 		}
@@ -100,7 +70,7 @@ func main() {
 	if len(os.Args) < 2 {
 		log.Fatal("commit-tree <sha1> [-p <sha1>]* < changelog")
 	}
-	treeSha1, err := hash.GetSha1Hex(os.Args[1])
+	treeSha1, err := objects.ResolveSha1Hex(os.Args[1])
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -109,7 +79,7 @@ func main() {
 		log.Fatal(err)
 	}
 	if len(parentSha1s) == 0 {
-		fmt.Printf("Committing initial tree %s\n", os.Args[1])
+		fmt.Fprintf(os.Stderr, "Committing initial tree %s\n", os.Args[1])
 	}
 	realCommitterName, err := getRealCommitterName()
 	if err != nil {
@@ -137,20 +107,28 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	// TODO: remove_special
-	commitBuffer := newBuffer()
-	commitBuffer.addBuffer(fmt.Sprintf("tree %s\n", treeSha1))
-	for _, parentSha1 := range parentSha1s {
-		commitBuffer.addBuffer(fmt.Sprintf("parent %s\n", parentSha1))
-	}
-	commitBuffer.addBuffer(fmt.Sprintf("author %s <%s> %d\n", realCommitterName, realCommitterEmail, realCommitterDate.Unix()))
-	commitBuffer.addBuffer(fmt.Sprintf("committer %s <%s> %d\n", committerName, committerEmail, committerDate.Unix()))
-	var comment string
-	fmt.Scan(&comment)
-	commitBuffer.addBuffer(comment)
-	commitBuffer.finishBuffer("commit ")
-	err = objects.WriteSha1File(commitBuffer.buffer)
+	message, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commit := &object.Commit{
+		Tree:    treeSha1,
+		Parents: parentSha1s,
+		Author: &object.Signature{
+			Name:  realCommitterName,
+			Email: realCommitterEmail,
+			When:  realCommitterDate,
+		},
+		Committer: &object.Signature{
+			Name:  committerName,
+			Email: committerEmail,
+			When:  committerDate,
+		},
+		Message: string(message),
+	}
+	sha1, err := object.Write(commit)
 	if err != nil {
 		log.Fatal(err)
 	}
+	fmt.Printf("%x\n", sha1)
 }
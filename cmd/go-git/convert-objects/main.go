@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/commitgraph"
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/pack"
+	"github.com/marutaku/go-git/internal/refs"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+var SHA1_SIZE = 20
+
+// converted maps an old object ID to its ID in the new format.
+var converted = map[string][]byte{}
+
+func writeObject(nodeType string, body []byte) ([]byte, error) {
+	contents := []byte(fmt.Sprintf("%s %d", nodeType, len(body)))
+	contents = append(contents, 0)
+	contents = append(contents, body...)
+	compressed, err := utils.Compress(contents)
+	if err != nil {
+		return nil, err
+	}
+	sha1, err := hash.CalculateObjectHash(contents, compressed)
+	if err != nil {
+		return nil, err
+	}
+	return sha1, objects.WriteSha1Buffer(sha1, compressed)
+}
+
+func convertTree(body []byte) ([]byte, error) {
+	newBody := make([]byte, 0, len(body))
+	offset := 0
+	for offset < len(body) {
+		nullByteIndex := bytes.IndexByte(body[offset:], 0)
+		if nullByteIndex < 0 || offset+nullByteIndex+1+SHA1_SIZE > len(body) {
+			return nil, fmt.Errorf("corrupt tree entry at offset %d", offset)
+		}
+		newBody = append(newBody, body[offset:offset+nullByteIndex+1]...)
+		offset += nullByteIndex + 1
+		sha1, err := convert(body[offset : offset+SHA1_SIZE])
+		if err != nil {
+			return nil, err
+		}
+		newBody = append(newBody, sha1...)
+		offset += SHA1_SIZE
+	}
+	return newBody, nil
+}
+
+// parseCommitReference reads the ID following "tree " or "parent ".
+// Older commit-tree versions wrote the raw 20 bytes instead of hex, so both
+// forms are accepted. It returns the ID and the length consumed, including
+// the trailing newline.
+func parseCommitReference(rest []byte) ([]byte, int, error) {
+	if len(rest) > SHA1_SIZE*2 && rest[SHA1_SIZE*2] == '\n' {
+		if sha1, err := hex.DecodeString(string(rest[:SHA1_SIZE*2])); err == nil {
+			return sha1, SHA1_SIZE*2 + 1, nil
+		}
+	}
+	if len(rest) > SHA1_SIZE && rest[SHA1_SIZE] == '\n' {
+		return rest[:SHA1_SIZE], SHA1_SIZE + 1, nil
+	}
+	return nil, 0, fmt.Errorf("malformed object reference")
+}
+
+func convertCommit(body []byte) ([]byte, error) {
+	newBody := make([]byte, 0, len(body))
+	offset := 0
+	for _, field := range []string{"tree ", "parent "} {
+		for bytes.HasPrefix(body[offset:], []byte(field)) {
+			offset += len(field)
+			sha1, size, err := parseCommitReference(body[offset:])
+			if err != nil {
+				return nil, fmt.Errorf("%s line: %w", field[:len(field)-1], err)
+			}
+			newSha1, err := convert(sha1)
+			if err != nil {
+				return nil, err
+			}
+			newBody = append(newBody, fmt.Sprintf("%s%x\n", field, newSha1)...)
+			offset += size
+			if field == "tree " {
+				break
+			}
+		}
+	}
+	return append(newBody, body[offset:]...), nil
+}
+
+// convertTag rewrites the "object" line, the only reference a tag holds.
+func convertTag(body []byte) ([]byte, error) {
+	field := []byte("object ")
+	if !bytes.HasPrefix(body, field) {
+		return nil, fmt.Errorf("object line: missing")
+	}
+	sha1, size, err := parseCommitReference(body[len(field):])
+	if err != nil {
+		return nil, fmt.Errorf("object line: %w", err)
+	}
+	newSha1, err := convert(sha1)
+	if err != nil {
+		return nil, err
+	}
+	newBody := []byte(fmt.Sprintf("object %x\n", newSha1))
+	return append(newBody, body[len(field)+size:]...), nil
+}
+
+func convert(sha1 []byte) ([]byte, error) {
+	if newSha1, ok := converted[string(sha1)]; ok {
+		return newSha1, nil
+	}
+	nodeType, body, err := objects.ReadSha1File(sha1)
+	if err != nil {
+		return nil, err
+	}
+	switch nodeType {
+	case "tree":
+		body, err = convertTree(body)
+	case "commit":
+		body, err = convertCommit(body)
+	case "tag":
+		body, err = convertTag(body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %x: %w", nodeType, sha1, err)
+	}
+	newSha1, err := writeObject(nodeType, body)
+	if err != nil {
+		return nil, err
+	}
+	converted[string(sha1)] = newSha1
+	return newSha1, nil
+}
+
+func rewriteIndex() error {
+	activeCache, err := cache.ReadCache()
+	if err != nil {
+		return err
+	}
+	for _, entry := range activeCache {
+		newSha1, ok := converted[string(entry.Sha1)]
+		if !ok {
+			return fmt.Errorf("%s: object %x is missing", entry.Name, entry.Sha1)
+		}
+		entry.Sha1 = newSha1
+	}
+	tmpIndexFilePath := fmt.Sprintf("%s/index.lock", env.GetSHA1FileDirectory())
+	newIndexFile, err := os.OpenFile(tmpIndexFilePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer newIndexFile.Close()
+	if err := activeCache.WriteCache(newIndexFile); err != nil {
+		os.Remove(tmpIndexFilePath)
+		return err
+	}
+	return durable.CommitFile(newIndexFile, fmt.Sprintf("%s/index", env.GetSHA1FileDirectory()), config.FSYNC_INDEX)
+}
+
+// convertRefs returns the new value of every ref, failing if a ref points
+// at an object that was not converted.
+func convertRefs() (map[string][]byte, error) {
+	newRefs := map[string][]byte{}
+	err := refs.ForEachRef(func(name string, sha1 []byte) error {
+		newSha1, ok := converted[string(sha1)]
+		if !ok {
+			return fmt.Errorf("%s: object %x is missing", name, sha1)
+		}
+		newRefs[name] = newSha1
+		return nil
+	})
+	return newRefs, err
+}
+
+// writeRefs points every ref at its converted object. A detached HEAD is
+// not a ref UpdateRef accepts, so it is written here the same way.
+func writeRefs(newRefs map[string][]byte) error {
+	for name, sha1 := range newRefs {
+		if name != "HEAD" {
+			if err := refs.UpdateRef(name, sha1); err != nil {
+				return err
+			}
+			continue
+		}
+		fileName := refs.GetRefFileName(name)
+		file, err := os.OpenFile(fileName+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return fmt.Errorf("unable to lock %s: %w", name, err)
+		}
+		if _, err := fmt.Fprintf(file, "%x\n", sha1); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+		if err := durable.CommitFile(file, fileName, config.FSYNC_REFERENCE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleFiles drops the files that list objects by their old IDs. The
+// bitmaps go with their packs; the commit-graph and multi-pack-index can be
+// written again with commit-graph write and multi-pack-index write.
+func removeStaleFiles(store *objects.RepositoryStore) error {
+	for _, fileName := range []string{commitgraph.FileName(), filepath.Join(store.Packs.Dir(), pack.MIDX_FILE_NAME)} {
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 1 {
+		log.Fatal("convert-objects")
+	}
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		log.Fatal(err)
+	}
+	version, err := repositoryConfig.RepositoryFormatVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if version >= config.FORMAT_GIT_IDS {
+		fmt.Println("repository already uses Git-compatible object IDs")
+		return
+	}
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		log.Fatal("convert-objects needs a repository object store")
+	}
+	var oldSha1s [][]byte
+	err = store.Iterate(func(sha1 []byte) error {
+		oldSha1s = append(oldSha1s, sha1)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 変換後のオブジェクトはすべてlooseで書かれるので、古いパックは丸ごと消せる
+	oldPacks := append([]*pack.Packfile(nil), store.Packs.Packs()...)
+	// 新しいIDで書き込むため、保存する前にメモリ上の設定だけ切り替える
+	repositoryConfig.Set("core.repositoryformatversion", strconv.Itoa(config.FORMAT_GIT_IDS))
+	for _, sha1 := range oldSha1s {
+		if _, err := convert(sha1); err != nil {
+			log.Fatal(err)
+		}
+	}
+	newRefs, err := convertRefs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := rewriteIndex(); err != nil {
+		log.Fatal("unable to rewrite index: ", err)
+	}
+	if err := writeRefs(newRefs); err != nil {
+		log.Fatal("unable to update refs: ", err)
+	}
+	if err := repositoryConfig.Save(); err != nil {
+		log.Fatal("unable to update config: ", err)
+	}
+	// ここから先は古い形式のものを消すだけ
+	if err := removeStaleFiles(store); err != nil {
+		log.Fatal(err)
+	}
+	for _, sha1 := range oldSha1s {
+		newSha1 := converted[string(sha1)]
+		fmt.Printf("%x %x\n", sha1, newSha1)
+		if bytes.Equal(sha1, newSha1) || !store.Loose.Has(sha1) {
+			continue
+		}
+		if err := store.Loose.Delete(sha1); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, p := range oldPacks {
+		if err := store.Packs.Remove(p); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "convert-objects")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(env.DB_ENVIRONMENT_KEY, dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// gitID is the ID Git gives an object, computed without this repository's
+// helpers.
+func gitID(nodeType string, body []byte) []byte {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", nodeType, len(body))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+func setFormatVersion(t *testing.T, version int) {
+	t.Helper()
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repositoryConfig.Set("core.repositoryformatversion", strconv.Itoa(version))
+}
+
+func treeBody(name string, sha1 []byte) []byte {
+	return append([]byte("100644 "+name+"\x00"), sha1...)
+}
+
+const signature = "A U Thor <author@example.com> 1700000000 +0000"
+
+func TestConvert(t *testing.T) {
+	blob := []byte("hello\n")
+	tests := []struct {
+		name string
+		// build writes the old objects and returns the one to convert
+		// together with the ID Git would give its converted form.
+		build func(t *testing.T) (old []byte, want []byte)
+	}{
+		{"blob", func(t *testing.T) ([]byte, []byte) {
+			return mustWrite(t, "blob", blob), gitID("blob", blob)
+		}},
+		{"tree", func(t *testing.T) ([]byte, []byte) {
+			oldBlob := mustWrite(t, "blob", blob)
+			return mustWrite(t, "tree", treeBody("file", oldBlob)), gitID("tree", treeBody("file", gitID("blob", blob)))
+		}},
+		{"commit with hex references", func(t *testing.T) ([]byte, []byte) {
+			oldTree := mustWrite(t, "tree", treeBody("file", mustWrite(t, "blob", blob)))
+			newTree := gitID("tree", treeBody("file", gitID("blob", blob)))
+			rootBody := func(tree []byte) []byte {
+				return []byte(fmt.Sprintf("tree %x\nauthor %s\ncommitter %s\n\nroot\n", tree, signature, signature))
+			}
+			oldRoot := mustWrite(t, "commit", rootBody(oldTree))
+			newRoot := gitID("commit", rootBody(newTree))
+			childBody := func(tree, parent []byte) []byte {
+				return []byte(fmt.Sprintf("tree %x\nparent %x\nauthor %s\ncommitter %s\n\nchild\n", tree, parent, signature, signature))
+			}
+			return mustWrite(t, "commit", childBody(oldTree, oldRoot)), gitID("commit", childBody(newTree, newRoot))
+		}},
+		{"commit with raw references", func(t *testing.T) ([]byte, []byte) {
+			oldTree := mustWrite(t, "tree", treeBody("file", mustWrite(t, "blob", blob)))
+			newTree := gitID("tree", treeBody("file", gitID("blob", blob)))
+			// 初期のcommit-treeは16進数ではなく生のIDを書いていた
+			old := append(append([]byte("tree "), oldTree...), "\nauthor "+signature+"\ncommitter "+signature+"\n\nold\n"...)
+			want := fmt.Sprintf("tree %x\nauthor %s\ncommitter %s\n\nold\n", newTree, signature, signature)
+			return mustWrite(t, "commit", old), gitID("commit", []byte(want))
+		}},
+		{"tag", func(t *testing.T) ([]byte, []byte) {
+			tagBody := func(object []byte) []byte {
+				return []byte(fmt.Sprintf("object %x\ntype blob\ntag v1\ntagger %s\n\nmessage\n", object, signature))
+			}
+			return mustWrite(t, "tag", tagBody(mustWrite(t, "blob", blob))), gitID("tag", tagBody(gitID("blob", blob)))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects.SetStore(objects.NewMemoryStore())
+			converted = map[string][]byte{}
+			setFormatVersion(t, config.FORMAT_COMPRESSED_IDS)
+			old, want := test.build(t)
+			// mainと同じく、変換前にメモリ上の設定だけを切り替える
+			setFormatVersion(t, config.FORMAT_GIT_IDS)
+			got, err := convert(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("convert(%x) = %x, want %x", old, got, want)
+			}
+			if !objects.HasSha1File(got) {
+				t.Errorf("the converted object %x was not written", got)
+			}
+			if again, err := convert(old); err != nil || !bytes.Equal(again, got) {
+				t.Errorf("converting again gave %x, %v", again, err)
+			}
+		})
+	}
+}
+
+func mustWrite(t *testing.T, nodeType string, body []byte) []byte {
+	t.Helper()
+	sha1, err := writeObject(nodeType, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha1
+}
+
+func TestConvertRejectsCorruptObjects(t *testing.T) {
+	tests := []struct {
+		nodeType string
+		body     []byte
+	}{
+		{"tree", []byte("100644 file\x00short")},
+		{"commit", []byte("tree 1234\n")},
+		{"tag", []byte("type blob\n")},
+	}
+	for _, test := range tests {
+		t.Run(test.nodeType, func(t *testing.T) {
+			objects.SetStore(objects.NewMemoryStore())
+			converted = map[string][]byte{}
+			setFormatVersion(t, config.FORMAT_COMPRESSED_IDS)
+			old := mustWrite(t, test.nodeType, test.body)
+			setFormatVersion(t, config.FORMAT_GIT_IDS)
+			if _, err := convert(old); err == nil {
+				t.Errorf("a corrupt %s was converted", test.nodeType)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+var USAGE = "count-objects [-v]"
+
+type counts struct {
+	loose         int
+	looseSize     int64
+	inPack        int
+	packs         int
+	packSize      int64
+	prunePackable int
+	garbage       int
+	garbageSize   int64
+}
+
+func count(store *objects.RepositoryStore) (*counts, error) {
+	c := &counts{}
+	// ヘッダを読まずにファイルのstatだけで数える
+	err := store.Loose.Iterate(func(sha1 []byte) error {
+		stat, err := os.Stat(store.Loose.FileName(sha1))
+		if err != nil {
+			return err
+		}
+		c.loose++
+		c.looseSize += objects.DiskUsage(stat)
+		// パックにもあるならprune-packedで消せる
+		if store.Packs.Has(sha1) {
+			c.prunePackable++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := store.Packs.Reload(); err != nil {
+		return nil, err
+	}
+	for _, p := range store.Packs.Packs() {
+		c.packs++
+		c.inPack += p.Index.Count()
+		for _, extension := range []string{".pack", ".idx"} {
+			stat, err := os.Stat(p.Name + extension)
+			if err != nil {
+				return nil, err
+			}
+			c.packSize += stat.Size()
+		}
+	}
+	garbage := func(path string, stat os.FileInfo) error {
+		c.garbage++
+		c.garbageSize += stat.Size()
+		return nil
+	}
+	if err := store.Loose.Garbage(garbage); err != nil {
+		return nil, err
+	}
+	if err := store.Packs.Garbage(garbage); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func main() {
+	verbose := false
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "-v", "--verbose":
+			verbose = true
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		log.Fatal("count-objects needs a repository object store")
+	}
+	c, err := count(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !verbose {
+		fmt.Printf("%d objects, %d kilobytes\n", c.loose, c.looseSize/1024)
+		return
+	}
+	fmt.Printf("count: %d\n", c.loose)
+	fmt.Printf("size: %d\n", c.looseSize/1024)
+	fmt.Printf("in-pack: %d\n", c.inPack)
+	fmt.Printf("packs: %d\n", c.packs)
+	fmt.Printf("size-pack: %d\n", c.packSize/1024)
+	fmt.Printf("prune-packable: %d\n", c.prunePackable)
+	fmt.Printf("garbage: %d\n", c.garbage)
+	fmt.Printf("size-garbage: %d\n", c.garbageSize/1024)
+}
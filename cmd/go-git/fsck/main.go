@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/refs"
+)
+
+// Exit code bits. 1 is left to fatal errors from log.Fatal.
+const (
+	EXIT_CORRUPT   = 2
+	EXIT_MISSING   = 4
+	EXIT_BAD_INDEX = 8
+)
+
+var USAGE = "fsck [--no-dangling]"
+
+var VALID_TREE_MODES = map[uint32]bool{
+	0100644: true,
+	0100755: true,
+	0120000: true,
+	0040000: true,
+	0160000: true,
+}
+
+type checker struct {
+	store      *objects.RepositoryStore
+	out        *bufio.Writer
+	exitCode   int
+	types      map[string]string
+	referenced map[string]string
+	rooted     map[string]bool
+}
+
+// report prints one finding per line as "<kind> <type> <id> [<reason>]".
+func (c *checker) report(kind string, nodeType string, sha1 []byte, reason string) {
+	if reason == "" {
+		fmt.Fprintf(c.out, "%s %s %x\n", kind, nodeType, sha1)
+		return
+	}
+	fmt.Fprintf(c.out, "%s %s %x %s\n", kind, nodeType, sha1, reason)
+}
+
+// warn reports something Git would object to that this repository's own
+// tools have written, without failing the check.
+func (c *checker) warn(nodeType string, sha1 []byte, reason string) {
+	c.report("warning", nodeType, sha1, reason)
+}
+
+func (c *checker) corrupt(nodeType string, sha1 []byte, reason string) {
+	c.report("corrupt", nodeType, sha1, reason)
+	c.exitCode |= EXIT_CORRUPT
+}
+
+// fatal stops the check on an error that leaves nothing more to check. The
+// deferred flush in main does not run after log.Fatal, so the findings
+// printed so far are flushed here.
+func (c *checker) fatal(err error) {
+	c.out.Flush()
+	log.Fatal(err)
+}
+
+func (c *checker) reference(sha1 []byte, nodeType string) {
+	if _, ok := c.referenced[string(sha1)]; !ok {
+		c.referenced[string(sha1)] = nodeType
+	}
+}
+
+// rehashLoose computes the ID of a loose object from what is on disk: the
+// compressed file itself in the original format, the inflated object otherwise.
+func rehashLoose(sha1 []byte) ([]byte, error) {
+	if hash.UncompressedObjectIDs() {
+		reader, err := objects.OpenSha1File(sha1)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return hash.CalculateSha1HashFromReader(reader.Type, reader.Size, reader)
+	}
+	file, err := os.Open(objects.GetSha1FileName(sha1))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := hash.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func (c *checker) checkObject(sha1 []byte, loose bool) {
+	if _, ok := c.types[string(sha1)]; ok {
+		return
+	}
+	reader, err := c.open(sha1, loose)
+	if err != nil {
+		c.types[string(sha1)] = "unknown"
+		c.corrupt("unknown", sha1, "unreadable")
+		return
+	}
+	nodeType := reader.Type
+	c.types[string(sha1)] = nodeType
+	var body []byte
+	if nodeType == "blob" {
+		_, err = io.Copy(io.Discard, reader)
+	} else {
+		body, err = io.ReadAll(reader)
+	}
+	reader.Close()
+	if err != nil {
+		c.corrupt(nodeType, sha1, "unreadable")
+		return
+	}
+	var actual []byte
+	if loose {
+		actual, err = rehashLoose(sha1)
+	} else if hash.UncompressedObjectIDs() {
+		// 元の形式のIDは圧縮後のバイト列から計算されるため、パック内のオブジェクトからは再計算できない
+		reader, err = c.open(sha1, false)
+		if err == nil {
+			actual, err = hash.CalculateSha1HashFromReader(reader.Type, reader.Size, reader)
+			reader.Close()
+		}
+	}
+	if err != nil {
+		c.corrupt(nodeType, sha1, "unreadable")
+		return
+	}
+	if actual != nil && !bytes.Equal(actual, sha1) {
+		c.corrupt(nodeType, sha1, "hashMismatch")
+	}
+	switch nodeType {
+	case "blob":
+	case "tree":
+		c.checkTree(sha1, body)
+	case "commit":
+		c.checkCommit(sha1, body)
+	case "tag":
+		c.checkTag(sha1, body)
+	default:
+		c.corrupt(nodeType, sha1, "badType")
+	}
+}
+
+func (c *checker) open(sha1 []byte, loose bool) (*objects.ObjectReader, error) {
+	if loose {
+		return c.store.Loose.Open(sha1)
+	}
+	return c.store.Packs.Open(sha1)
+}
+
+// parseReason returns the fsck reason for an object that failed to parse.
+func parseReason(err error, fallback string) string {
+	var parseError *object.ParseError
+	if errors.As(err, &parseError) {
+		return parseError.Reason
+	}
+	return fallback
+}
+
+// validEntryName accepts the full paths write-tree puts in its flat trees as
+// well as plain names, as long as no component is empty, "." or "..".
+func validEntryName(name string) bool {
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component == "." || component == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *checker) checkTree(sha1 []byte, body []byte) {
+	tree, err := object.ParseTree(body)
+	if err != nil {
+		c.corrupt("tree", sha1, parseReason(err, "badTree"))
+		return
+	}
+	names := map[string]bool{}
+	for i, entry := range tree.Entries {
+		if !VALID_TREE_MODES[entry.Mode] {
+			c.corrupt("tree", sha1, fmt.Sprintf("badFilemode:%o", entry.Mode))
+		}
+		if !validEntryName(entry.Name) {
+			c.corrupt("tree", sha1, "badEntryName")
+		}
+		if names[entry.Name] {
+			c.corrupt("tree", sha1, "duplicateEntries")
+		}
+		names[entry.Name] = true
+		// 以前のwrite-treeはindexの順(引数の順)のまま書いていた
+		if i > 0 && tree.Entries[i-1].SortName() > entry.SortName() {
+			c.warn("tree", sha1, "treeNotSorted")
+		}
+		switch {
+		case entry.IsGitlink():
+		case entry.IsTree():
+			c.reference(entry.Sha1, "tree")
+		default:
+			c.reference(entry.Sha1, "blob")
+		}
+	}
+}
+
+func (c *checker) checkCommit(sha1 []byte, body []byte) {
+	commit, err := object.ParseCommit(body)
+	if err != nil {
+		c.corrupt("commit", sha1, parseReason(err, "badCommitHeader"))
+		return
+	}
+	c.reference(commit.Tree, "tree")
+	for _, parent := range commit.Parents {
+		c.reference(parent, "commit")
+	}
+}
+
+func (c *checker) checkTag(sha1 []byte, body []byte) {
+	tag, err := object.ParseTag(body)
+	if err != nil {
+		c.corrupt("tag", sha1, parseReason(err, "badTagHeader"))
+		return
+	}
+	if refs.VerifyRefName("refs/tags/"+tag.Tag) != nil {
+		c.corrupt("tag", sha1, "badTagName")
+	}
+	c.reference(tag.Object, tag.TargetType)
+}
+
+func (c *checker) checkIndex() {
+	activeCache, err := cache.ReadCache()
+	if err != nil {
+		fmt.Fprintf(c.out, "badindex - - unreadable\n")
+		c.exitCode |= EXIT_BAD_INDEX
+		return
+	}
+	for _, entry := range activeCache {
+		c.rooted[string(entry.Sha1)] = true
+		nodeType, ok := c.types[string(entry.Sha1)]
+		switch {
+		case !ok && !c.store.Has(entry.Sha1):
+			fmt.Fprintf(c.out, "badindex %x missingObject %s\n", entry.Sha1, entry.Name)
+			c.exitCode |= EXIT_BAD_INDEX
+		case ok && nodeType != "blob":
+			fmt.Fprintf(c.out, "badindex %x notABlob %s\n", entry.Sha1, entry.Name)
+			c.exitCode |= EXIT_BAD_INDEX
+		}
+	}
+}
+
+func (c *checker) checkRefs() {
+	err := refs.ForEachRef(func(name string, sha1 []byte) error {
+		c.rooted[string(sha1)] = true
+		if _, ok := c.types[string(sha1)]; !ok && !c.store.Has(sha1) {
+			fmt.Fprintf(c.out, "badref %x missingObject %s\n", sha1, name)
+			c.exitCode |= EXIT_MISSING
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(c.out, "badref - - unreadable\n")
+		c.exitCode |= EXIT_CORRUPT
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func main() {
+	showDangling := true
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--no-dangling":
+			showDangling = false
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		log.Fatal(errors.New("fsck needs a repository object store"))
+	}
+	c := &checker{
+		store:      store,
+		out:        bufio.NewWriter(os.Stdout),
+		types:      map[string]string{},
+		referenced: map[string]string{},
+		rooted:     map[string]bool{},
+	}
+	defer func() {
+		c.out.Flush()
+		os.Exit(c.exitCode)
+	}()
+	err := store.Loose.Iterate(func(sha1 []byte) error {
+		c.checkObject(sha1, true)
+		return nil
+	})
+	if err != nil {
+		c.fatal(err)
+	}
+	if err := store.Packs.Reload(); err != nil {
+		c.fatal(err)
+	}
+	for _, p := range store.Packs.Packs() {
+		if err := p.Verify(); err != nil {
+			c.corrupt("pack", p.Index.PackChecksum, "badPack")
+		}
+		for i := 0; i < p.Index.Count(); i++ {
+			c.checkObject(p.Index.Sha1(i), false)
+		}
+	}
+	c.checkIndex()
+	c.checkRefs()
+	for _, key := range sortedKeys(c.referenced) {
+		nodeType, ok := c.types[key]
+		if !ok {
+			// 代替オブジェクトディレクトリから借りているオブジェクトは欠損ではない
+			borrowedType, _, err := objects.ReadSha1Header([]byte(key))
+			if err != nil {
+				c.report("missing", c.referenced[key], []byte(key), "")
+				c.exitCode |= EXIT_MISSING
+				continue
+			}
+			nodeType = borrowedType
+		}
+		if nodeType != c.referenced[key] {
+			c.corrupt(nodeType, []byte(key), "typeMismatch")
+		}
+	}
+	if !showDangling {
+		return
+	}
+	for _, key := range sortedKeys(c.types) {
+		if _, ok := c.referenced[key]; ok || c.rooted[key] {
+			continue
+		}
+		c.report("dangling", c.types[key], []byte(key), "")
+	}
+}
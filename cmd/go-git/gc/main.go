@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marutaku/go-git/internal/packer"
+	"github.com/marutaku/go-git/internal/prune"
+)
+
+var USAGE = "gc [--prune=<now|never|duration>]"
+
+// gc packs everything reachable into a single pack, then prunes the loose
+// objects nothing refers to any more.
+func main() {
+	now := time.Now()
+	expire, err := prune.DefaultExpire(now)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, arg := range os.Args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "--prune="):
+			if expire, err = prune.ParseExpire(strings.TrimPrefix(arg, "--prune="), now); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	reachable, err := prune.Reachable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	packOptions, err := packer.DefaultOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeBitmap, err := packer.DefaultWriteBitmap()
+	if err != nil {
+		log.Fatal(err)
+	}
+	checksum, err := packer.Repack(&packer.RepackOptions{
+		All:         true,
+		Delete:      true,
+		Reachable:   reachable,
+		WriteBitmap: writeBitmap,
+		Pack:        packOptions,
+	})
+	if err != nil {
+		log.Fatal("unable to repack: ", err)
+	}
+	if checksum != nil {
+		fmt.Printf("%x\n", checksum)
+	}
+	if err := prune.Prune(&prune.Options{Expire: expire, Reachable: reachable}); err != nil {
+		log.Fatal("unable to prune: ", err)
+	}
+	if err := prune.CleanStaleFiles(expire, false, nil); err != nil {
+		log.Fatal("unable to remove stale files: ", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+var USAGE = "hash-object [-t <type>] [-w] [--stdin] [--stdin-paths] [--] <file>..."
+
+type options struct {
+	objType string
+	write   bool
+}
+
+// hashObject prints the ID of an object of size bytes read from r, and with
+// -w stores it. Anything but a blob must parse as its type.
+func (o *options) hashObject(size int64, r io.Reader) error {
+	if o.objType != "blob" {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if _, err := object.Parse(o.objType, body); err != nil {
+			return err
+		}
+		r = bytes.NewReader(body)
+	}
+	var sha1 []byte
+	var err error
+	if o.write {
+		sha1, err = objects.WriteSha1Stream(o.objType, size, r)
+	} else {
+		sha1, err = hash.CalculateSha1HashFromReader(o.objType, size, r)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%x\n", sha1)
+	return nil
+}
+
+func (o *options) hashFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := o.hashObject(stat.Size(), file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func main() {
+	o := &options{objType: "blob"}
+	fromStdin := false
+	stdinPaths := false
+	var paths []string
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		case arg == "-t":
+			if i+1 >= len(args) {
+				log.Fatal(USAGE)
+			}
+			i++
+			o.objType = args[i]
+		case arg == "-w":
+			o.write = true
+		case arg == "--stdin":
+			fromStdin = true
+		case arg == "--stdin-paths":
+			stdinPaths = true
+		case len(arg) > 1 && arg[0] == '-':
+			log.Fatal(USAGE)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	switch o.objType {
+	case "blob", "tree", "commit", "tag":
+	default:
+		log.Fatalf("invalid object type %q", o.objType)
+	}
+	if fromStdin && stdinPaths {
+		log.Fatal("--stdin and --stdin-paths are incompatible")
+	}
+	if stdinPaths && len(paths) > 0 {
+		log.Fatal("--stdin-paths takes no file arguments")
+	}
+	if fromStdin {
+		// 長さがヘッダに必要なので標準入力は一度すべて読み込む
+		body, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := o.hashObject(int64(len(body)), bytes.NewReader(body)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, path := range paths {
+		if err := o.hashFile(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if stdinPaths {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if err := o.hashFile(scanner.Text()); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -4,11 +4,60 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/marutaku/go-git/internal/config"
 	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/utils"
 )
 
 func main() {
+	formatVersion := -1
+	objectFormat := hash.SHA1
+	compression := utils.DefaultCompression
+	for _, arg := range os.Args[1:] {
+		switch {
+		case strings.HasPrefix(arg, "--repository-format="):
+			version, err := strconv.Atoi(strings.TrimPrefix(arg, "--repository-format="))
+			if err != nil || (version != config.FORMAT_COMPRESSED_IDS && version != config.FORMAT_GIT_IDS) {
+				log.Fatalf("unknown repository format: %s", arg)
+			}
+			formatVersion = version
+		case strings.HasPrefix(arg, "--object-format="):
+			algorithm, ok := hash.AlgorithmByName(strings.TrimPrefix(arg, "--object-format="))
+			if !ok {
+				log.Fatalf("unknown object format: %s", arg)
+			}
+			objectFormat = algorithm
+		case strings.HasPrefix(arg, "--compression="):
+			preset, ok := utils.LookupCompressionPreset(strings.TrimPrefix(arg, "--compression="))
+			if !ok {
+				log.Fatalf("unknown compression: %s", arg)
+			}
+			compression = preset
+		default:
+			log.Fatal("init-db [--repository-format=<0|1>] [--object-format=<sha1|sha256>] [--compression=<zlib|zlib-fast|zlib-best|zstd>]")
+		}
+	}
+	// Only Git-compatible object IDs can be computed with another hash.
+	if objectFormat != hash.SHA1 {
+		if formatVersion == config.FORMAT_COMPRESSED_IDS {
+			log.Fatalf("--object-format=%s requires --repository-format=%d", objectFormat.Name, config.FORMAT_GIT_IDS)
+		}
+		formatVersion = config.FORMAT_GIT_IDS
+	}
+	// Legacy object IDs hash the compressed bytes, so they depend on the codec.
+	if compression != utils.DefaultCompression {
+		if formatVersion == config.FORMAT_COMPRESSED_IDS {
+			log.Fatalf("--compression requires --repository-format=%d", config.FORMAT_GIT_IDS)
+		}
+		formatVersion = config.FORMAT_GIT_IDS
+	}
+	if formatVersion < 0 {
+		formatVersion = config.FORMAT_COMPRESSED_IDS
+	}
 	sha1Dir := env.GetSHA1FileDirectory()
 	if err := os.Mkdir(sha1Dir, 0700); err != nil {
 		log.Fatalf("error: %v\n", err)
@@ -22,4 +71,16 @@ func main() {
 			log.Fatalf("error: %v\n", err)
 		}
 	}
+	repositoryConfig := &config.Config{}
+	repositoryConfig.Set("core.repositoryformatversion", strconv.Itoa(formatVersion))
+	if objectFormat != hash.SHA1 {
+		repositoryConfig.Set("extensions.objectformat", objectFormat.Name)
+	}
+	if compression != utils.DefaultCompression {
+		repositoryConfig.Set("core.objectcodec", compression.Codec)
+		repositoryConfig.Set("core.compression", strconv.Itoa(compression.Level))
+	}
+	if err := repositoryConfig.Save(); err != nil {
+		log.Fatalf("error: %v\n", err)
+	}
 }
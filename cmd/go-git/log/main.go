@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var USAGE = "log [-n <n>] [--oneline] <commit>... [-- <path>...]"
+
+var DATE_FORMAT = "Mon Jan 2 15:04:05 2006 -0700"
+
+func printCommit(out *bufio.Writer, sha1 []byte, oneline bool) error {
+	commit, err := object.ReadCommit(sha1)
+	if err != nil {
+		return fmt.Errorf("commit %x: %w", sha1, err)
+	}
+	if oneline {
+		subject, _, _ := strings.Cut(strings.TrimLeft(commit.Message, "\n"), "\n")
+		fmt.Fprintf(out, "%x %s\n", sha1, subject)
+		return nil
+	}
+	fmt.Fprintf(out, "commit %x\n", sha1)
+	if len(commit.Parents) > 1 {
+		fmt.Fprint(out, "Merge:")
+		for _, parent := range commit.Parents {
+			abbrev, err := objects.Abbreviate(parent, objects.DEFAULT_ABBREV)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, " %s", abbrev)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "Author: %s <%s>\n", commit.Author.Name, commit.Author.Email)
+	fmt.Fprintf(out, "Date:   %s\n\n", commit.Author.When.Format(DATE_FORMAT))
+	for _, line := range strings.Split(strings.TrimRight(commit.Message, "\n"), "\n") {
+		fmt.Fprintf(out, "    %s\n", line)
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+func main() {
+	limit := -1
+	oneline := false
+	var tips [][]byte
+	var paths []string
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			paths = args[i+1:]
+			i = len(args)
+		case args[i] == "-n" && i+1 < len(args):
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				log.Fatal(USAGE)
+			}
+			limit = n
+		case args[i] == "--oneline":
+			oneline = true
+		case strings.HasPrefix(args[i], "-"):
+			log.Fatal(USAGE)
+		default:
+			sha1, err := objects.ResolveSha1Hex(args[i])
+			if err != nil {
+				log.Fatal(err)
+			}
+			commit, err := object.Peel(sha1, "commit")
+			if err != nil {
+				log.Fatalf("%s: not a commit: %v", args[i], err)
+			}
+			tips = append(tips, commit)
+		}
+	}
+	if len(tips) == 0 {
+		log.Fatal(USAGE)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	count := 0
+	show := func(commit *walk.Commit) error {
+		if count == limit {
+			return walk.ErrStop
+		}
+		count++
+		return printCommit(out, commit.Sha1, oneline)
+	}
+	var err error
+	if len(paths) > 0 {
+		err = walk.PathHistory(tips, paths, show)
+	} else {
+		err = walk.History(tips, show)
+	}
+	if err != nil {
+		out.Flush()
+		log.Fatal(err)
+	}
+}
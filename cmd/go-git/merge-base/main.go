@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var USAGE = "merge-base [--all] <commit> <commit> | merge-base --is-ancestor <commit> <commit>"
+
+func resolveCommit(name string) []byte {
+	sha1, err := objects.ResolveSha1Hex(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commit, err := object.Peel(sha1, "commit")
+	if err != nil {
+		log.Fatalf("%s: not a commit: %v", name, err)
+	}
+	return commit
+}
+
+func main() {
+	all := false
+	isAncestor := false
+	var names []string
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--all":
+			all = true
+		case "--is-ancestor":
+			isAncestor = true
+		default:
+			names = append(names, arg)
+		}
+	}
+	if len(names) != 2 || (all && isAncestor) {
+		log.Fatal(USAGE)
+	}
+	one := resolveCommit(names[0])
+	two := resolveCommit(names[1])
+	if isAncestor {
+		ancestor, err := walk.IsAncestor(one, two)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ancestor {
+			os.Exit(1)
+		}
+		return
+	}
+	bases, err := walk.MergeBases(one, two)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(bases) == 0 {
+		os.Exit(1)
+	}
+	if !all {
+		bases = bases[:1]
+	}
+	for _, base := range bases {
+		fmt.Printf("%x\n", base)
+	}
+}
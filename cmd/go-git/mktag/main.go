@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/refs"
+)
+
+var USAGE = "mktag < signature"
+
+// mktag reads a tag body from stdin, checks it and writes the tag object.
+// The tagged object must exist and have the declared type.
+func main() {
+	if len(os.Args) != 1 {
+		log.Fatal(USAGE)
+	}
+	body, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tag, err := object.ParseTag(body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tag.Tagger == nil {
+		log.Fatal("malformed tag: missing tagger")
+	}
+	if err := refs.VerifyRefName("refs/tags/" + tag.Tag); err != nil {
+		log.Fatalf("malformed tag: bad tag name %q", tag.Tag)
+	}
+	if err := tag.CheckTarget(); err != nil {
+		log.Fatal(err)
+	}
+	// 検証したバイト列をそのまま書く。再エンコードするとIDが変わりうる
+	sha1, err := objects.WriteSha1Stream("tag", int64(len(body)), bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%x\n", sha1)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/pack"
+)
+
+var USAGE = "multi-pack-index (write | verify)"
+
+// verify checks the checksum of the multi-pack-index and that every object
+// it lists is where the pack's own index says it is.
+func verify(store *objects.RepositoryStore) error {
+	fileName := filepath.Join(store.Packs.Dir(), pack.MIDX_FILE_NAME)
+	midx, err := pack.ReadMultiPackIndex(fileName)
+	if err != nil {
+		return err
+	}
+	if err := midx.Verify(fileName); err != nil {
+		return err
+	}
+	var packs []*pack.Packfile
+	for _, name := range midx.PackNames {
+		p, err := pack.Open(filepath.Join(store.Packs.Dir(), name))
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		packs = append(packs, p)
+	}
+	for i := 0; i < midx.Count(); i++ {
+		sha1 := midx.Sha1(i)
+		if i > 0 && bytes.Compare(midx.Sha1(i-1), sha1) >= 0 {
+			return fmt.Errorf("object IDs out of order at %x", sha1)
+		}
+		packID, offset := midx.Object(i)
+		if packID >= len(packs) {
+			return fmt.Errorf("%x: bad pack id %d", sha1, packID)
+		}
+		packOffset, ok := packs[packID].Index.Find(sha1)
+		if !ok || packOffset != offset {
+			return fmt.Errorf("%x: offset %d does not match %s", sha1, offset, midx.PackNames[packID])
+		}
+	}
+	for _, p := range packs {
+		for i := 0; i < p.Index.Count(); i++ {
+			if _, _, ok := midx.Find(p.Index.Sha1(i)); !ok {
+				return fmt.Errorf("%x from %s is missing", p.Index.Sha1(i), filepath.Base(p.Name))
+			}
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal(USAGE)
+	}
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		log.Fatal("multi-pack-index needs a repository object store")
+	}
+	switch os.Args[1] {
+	case "write":
+		if err := store.Packs.WriteMultiPackIndex(); err != nil {
+			log.Fatal("unable to write multi-pack-index: ", err)
+		}
+	case "verify":
+		if err := verify(store); err != nil {
+			log.Fatal("multi-pack-index: ", err)
+		}
+	default:
+		log.Fatal(USAGE)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/packer"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var USAGE = "pack-objects [--revs] [--window=<n>] [--depth=<n>] [<base-name>] < object-list"
+
+// readObjectList reads "<sha1> [<path>]" lines from stdin.
+func readObjectList() ([]*walk.Object, error) {
+	var objectList []*walk.Object
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sha1Hex, path, _ := strings.Cut(line, " ")
+		sha1, err := hash.GetSha1Hex(sha1Hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object name %q", sha1Hex)
+		}
+		objectList = append(objectList, &walk.Object{Sha1: sha1, Path: path})
+	}
+	return objectList, scanner.Err()
+}
+
+func main() {
+	revs := false
+	baseName := ""
+	options, err := packer.DefaultOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--revs":
+			revs = true
+		case strings.HasPrefix(arg, "--window="):
+			if options.Window, err = strconv.Atoi(strings.TrimPrefix(arg, "--window=")); err != nil {
+				log.Fatal(USAGE)
+			}
+		case strings.HasPrefix(arg, "--depth="):
+			if options.Depth, err = strconv.Atoi(strings.TrimPrefix(arg, "--depth=")); err != nil {
+				log.Fatal(USAGE)
+			}
+		case strings.HasPrefix(arg, "-"):
+			log.Fatal(USAGE)
+		case baseName == "":
+			baseName = arg
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	if baseName == "" {
+		baseName = fmt.Sprintf("%s/pack", objects.GetPackDirectory())
+	}
+	objectList, err := readObjectList()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if revs {
+		var roots [][]byte
+		for _, object := range objectList {
+			roots = append(roots, object.Sha1)
+		}
+		objectList = nil
+		err := walk.Reachable(roots, func(object *walk.Object) error {
+			objectList = append(objectList, object)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	checksum, err := packer.WritePack(baseName, objectList, options)
+	if err != nil {
+		log.Fatal("unable to write pack: ", err)
+	}
+	fmt.Printf("%x\n", checksum)
+}
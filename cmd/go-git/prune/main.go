@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marutaku/go-git/internal/prune"
+)
+
+var USAGE = "prune [-n] [-v] [--expire=<now|never|duration>]"
+
+func main() {
+	now := time.Now()
+	expire, err := prune.DefaultExpire(now)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options := &prune.Options{}
+	verbose := false
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "-n":
+			options.DryRun = true
+		case arg == "-v":
+			verbose = true
+		case strings.HasPrefix(arg, "--expire="):
+			if expire, err = prune.ParseExpire(strings.TrimPrefix(arg, "--expire="), now); err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	options.Expire = expire
+	if options.DryRun || verbose {
+		options.Report = func(sha1 []byte, nodeType string) {
+			fmt.Printf("%x %s\n", sha1, nodeType)
+		}
+	}
+	if err := prune.Prune(options); err != nil {
+		log.Fatal("unable to prune: ", err)
+	}
+	var report func(path string)
+	if options.DryRun || verbose {
+		report = func(path string) {
+			fmt.Printf("Removing stale temporary file %s\n", path)
+		}
+	}
+	if err := prune.CleanStaleFiles(expire, options.DryRun, report); err != nil {
+		log.Fatal("unable to remove stale files: ", err)
+	}
+}
@@ -1,50 +1,67 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/marutaku/go-git/internal/object"
 	"github.com/marutaku/go-git/internal/objects"
 )
 
-func unpack(sha1 []byte) error {
-	nodeType, byteBuffer, err := objects.ReadSha1File(sha1)
+var USAGE = "Usage: read-tree [--abbrev[=<n>]] <key>"
+
+// unpack lists the entries of a tree. With abbrev above zero, IDs are
+// shortened to unique prefixes of at least that length.
+func unpack(sha1 []byte, abbrev int) error {
+	tree, err := object.ReadTree(sha1)
 	if err != nil {
 		return err
 	}
-	if nodeType != "tree" {
-		return fmt.Errorf("invalid node type: %s", nodeType)
-	}
-	size := len(byteBuffer)
-	offset := 0
-	for offset < size {
-		var mode int
-		var name string
-		var sha1 []byte
-		nullByteIndex := bytes.IndexByte(byteBuffer[offset:], 0)
-		fileInfo := string(byteBuffer[offset : offset+nullByteIndex])
-		fmt.Sscanf(fileInfo, "%o %s", &mode, &name)
-		offset += nullByteIndex + 1
-		sha1 = byteBuffer[offset : offset+20]
-		fmt.Printf("%o %s (%x)\n", mode, name, sha1)
-		offset += 20
+	for _, entry := range tree.Entries {
+		name := hex.EncodeToString(entry.Sha1)
+		if abbrev > 0 {
+			if name, err = objects.Abbreviate(entry.Sha1, abbrev); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%o %s (%s)\n", entry.Mode, entry.Name, name)
 	}
 	return nil
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: read-tree <key>")
+	abbrev := 0
+	args := os.Args[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "--abbrev") {
+		switch {
+		case args[0] == "--abbrev":
+			abbrev = objects.DEFAULT_ABBREV
+		case strings.HasPrefix(args[0], "--abbrev="):
+			n, err := strconv.Atoi(strings.TrimPrefix(args[0], "--abbrev="))
+			if err != nil || n < 0 {
+				fmt.Println(USAGE)
+				os.Exit(1)
+			}
+			abbrev = n
+		default:
+			fmt.Println(USAGE)
+			os.Exit(1)
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Println(USAGE)
 		os.Exit(1)
 	}
-	sha1, err := hex.DecodeString(os.Args[1])
+	sha1, err := objects.ResolveSha1Hex(args[0])
 	if err != nil {
-		fmt.Println("Invalid sha1")
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	err = unpack(sha1)
+	err = unpack(sha1, abbrev)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/packer"
+)
+
+var USAGE = "repack [-a] [-d] [-b | --[no-]write-bitmap-index] [--window=<n>] [--depth=<n>]"
+
+func main() {
+	packOptions, err := packer.DefaultOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	writeBitmap, err := packer.DefaultWriteBitmap()
+	if err != nil {
+		log.Fatal(err)
+	}
+	options := &packer.RepackOptions{Pack: packOptions, WriteBitmap: writeBitmap}
+	bitmapOption := false
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "-a":
+			options.All = true
+		case arg == "-d":
+			options.Delete = true
+		case arg == "-ad" || arg == "-da":
+			options.All = true
+			options.Delete = true
+		case arg == "-b" || arg == "--write-bitmap-index":
+			options.WriteBitmap = true
+			bitmapOption = true
+		case arg == "--no-write-bitmap-index":
+			options.WriteBitmap = false
+		case strings.HasPrefix(arg, "--window="):
+			if packOptions.Window, err = strconv.Atoi(strings.TrimPrefix(arg, "--window=")); err != nil {
+				log.Fatal(USAGE)
+			}
+		case strings.HasPrefix(arg, "--depth="):
+			if packOptions.Depth, err = strconv.Atoi(strings.TrimPrefix(arg, "--depth=")); err != nil {
+				log.Fatal(USAGE)
+			}
+		default:
+			log.Fatal(USAGE)
+		}
+	}
+	if options.WriteBitmap && !options.All {
+		if bitmapOption {
+			log.Fatal("bitmaps need -a")
+		}
+		// repack.writebitmapsはすべてを詰め直すときだけ効く
+		options.WriteBitmap = false
+	}
+	checksum, err := packer.Repack(options)
+	if err != nil {
+		log.Fatal("unable to repack: ", err)
+	}
+	if checksum == nil {
+		fmt.Println("Nothing new to pack.")
+		return
+	}
+	fmt.Printf("%x\n", checksum)
+}
@@ -1,45 +1,65 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
 	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/hash"
 )
 
+var USAGE = "update-cache [--refresh] [--add] [--remove] [--force-remove] [--cacheinfo <mode>,<sha1>,<path>] [--index-info] [--] [<file>...]"
+
 var activeCache cache.ActiveCache
 
+var (
+	allowAdd    = false
+	allowRemove = false
+)
+
+// checkAdd refuses an entry for a path the index does not have yet unless
+// --add was given.
+func checkAdd(entry *cache.CacheEntry) error {
+	if !allowAdd && activeCache.FindCacheEntryIndex(entry) == -1 {
+		return fmt.Errorf("%s: cannot add to the index - missing --add option?", entry.Name)
+	}
+	return nil
+}
+
 func addCacheEntry(entry *cache.CacheEntry) error {
-	existingEntryIndex := activeCache.FindCacheEntryIndex(entry)
-	if existingEntryIndex != -1 {
-		activeCache[existingEntryIndex] = entry
-		return nil
+	if err := checkAdd(entry); err != nil {
+		return err
 	}
-	activeCache = append(activeCache, entry)
+	activeCache.AddCacheEntry(entry)
 	return nil
 }
 
 func addFileToCache(path string) error {
-	file, err := os.Open(path)
+	file, stat, err := cache.OpenContents(path)
 	if err != nil {
-		return err
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// 作業ツリーから消えたファイル
+		if !allowRemove {
+			return fmt.Errorf("%s: does not exist and --remove not passed", path)
+		}
+		activeCache.RemoveCacheEntry(path)
+		return nil
 	}
 	defer file.Close()
-	stat, err := file.Stat()
-	if err != nil {
-		return err
-	}
-	fileContent := make([]byte, stat.Size())
-	_, err = file.Read(fileContent)
-	if err != nil {
-		return err
-	}
-	entry, err := cache.NewCacheEntryFromFilePath(path, fileContent)
+	entry, err := cache.NewCacheEntryFromFilePath(path, file)
 	if err != nil {
 		return err
 	}
@@ -48,34 +68,178 @@ func addFileToCache(path string) error {
 			// 全く同じであれば何もしない
 			return nil
 		}
-		return addCacheEntry(entry)
 	}
-	err = entry.IndexFd(fileContent, stat)
+	// 断られるならオブジェクトを書く前に
+	if err := checkAdd(entry); err != nil {
+		return err
+	}
+	// ハッシュ計算で読み切ったので先頭から読み直す
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = entry.IndexFd(file, stat)
 	if err != nil {
 		return err
 	}
-	return addCacheEntry(entry)
+	activeCache.AddCacheEntry(entry)
+	return nil
 }
 
+// verifyPath accepts relative paths whose components are not empty, "." or
+// "..", and that do not reach into a .git directory.
 func verifyPath(path string) bool {
-	if strings.Contains(path, "..") || strings.Contains(path, "//") || strings.HasSuffix(path, "/") {
+	if path == "" || filepath.IsAbs(path) {
 		return false
 	}
-	if filepath.Base(path)[0] == '.' {
-		return false
+	for _, component := range strings.Split(path, "/") {
+		if component == "" || component == "." || component == ".." || strings.EqualFold(component, ".git") {
+			return false
+		}
 	}
 	return true
 }
 
-func renameIndexFile() {
-	srcIndexFilePath := fmt.Sprintf("%s/index.lock", env.GetSHA1FileDirectory())
-	dstIndexFilePath := fmt.Sprintf("%s/index", env.GetSHA1FileDirectory())
-	os.Rename(srcIndexFilePath, dstIndexFilePath)
+// refreshCache updates the stat data of entries whose files changed only in
+// their metadata, such as after a touch. It prints the paths whose contents
+// differ and reports whether there were any.
+func refreshCache() (bool, error) {
+	needsUpdate := false
+	for i, entry := range activeCache {
+		if entry.Stage() != 0 {
+			fmt.Printf("%s: needs merge\n", entry.Name)
+			needsUpdate = true
+			continue
+		}
+		stat, err := os.Lstat(entry.Name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			fmt.Printf("%s: needs update\n", entry.Name)
+			needsUpdate = true
+			continue
+		}
+		changed, err := entry.Changed(stat)
+		if err != nil {
+			return false, err
+		}
+		if changed == 0 {
+			continue
+		}
+		refreshed, err := refreshEntry(entry, changed)
+		if err != nil {
+			return false, err
+		}
+		if refreshed == nil {
+			fmt.Printf("%s: needs update\n", entry.Name)
+			needsUpdate = true
+			continue
+		}
+		activeCache[i] = refreshed
+	}
+	return needsUpdate, nil
+}
+
+// refreshEntry returns entry with fresh stat data, or nil when the file's
+// contents or mode are no longer what the entry records.
+func refreshEntry(entry *cache.CacheEntry, changed int) (*cache.CacheEntry, error) {
+	if changed&(cache.TYPE_CHANGED|cache.MODE_CHANGED) != 0 {
+		return nil, nil
+	}
+	file, _, err := cache.OpenContents(entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	refreshed, err := cache.NewCacheEntryFromFilePath(entry.Name, file)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(refreshed.Sha1, entry.Sha1) {
+		return nil, nil
+	}
+	refreshed.Flags = entry.Flags
+	refreshed.ExtendedFlags = entry.ExtendedFlags
+	return refreshed, nil
+}
+
+// addCacheInfo stages the object sha1 at path without looking at the work
+// tree.
+func addCacheInfo(mode string, sha1Hex string, path string, stage int) error {
+	if !verifyPath(path) {
+		return fmt.Errorf("%s: invalid path", path)
+	}
+	modeValue, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("bad mode %q", mode)
+	}
+	sha1, err := hash.GetSha1Hex(sha1Hex)
+	if err != nil {
+		return err
+	}
+	return addCacheEntry(cache.NewCacheEntry(uint32(modeValue), sha1, path, stage))
+}
+
+// applyIndexInfo reads records in any of the formats Git accepts, as
+// ls-tree or ls-files --stage print them:
+//
+//	<mode> SP <type> SP <sha1> TAB <path>
+//	<mode> SP <sha1> SP <stage> TAB <path>
+//	<mode> SP <sha1> TAB <path>
+//
+// A mode of 0 or a null object ID removes the path. Like Git, records may
+// add paths without --add.
+func applyIndexInfo(r io.Reader) error {
+	allowAdd = true
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		info, path, ok := strings.Cut(line, "\t")
+		if !ok {
+			return fmt.Errorf("malformed index info %q", line)
+		}
+		if strings.HasPrefix(path, "\"") {
+			unquoted, err := strconv.Unquote(path)
+			if err != nil {
+				return fmt.Errorf("malformed index info %q", line)
+			}
+			path = unquoted
+		}
+		fields := strings.Fields(info)
+		stage := 0
+		switch len(fields) {
+		case 2:
+		case 3:
+			if n, err := strconv.Atoi(fields[2]); err == nil && len(fields[2]) == 1 {
+				if n > 3 {
+					return fmt.Errorf("malformed index info %q", line)
+				}
+				stage = n
+				fields = fields[:2]
+			} else {
+				// ls-treeの出力はタイプを含む
+				fields = []string{fields[0], fields[2]}
+			}
+		default:
+			return fmt.Errorf("malformed index info %q", line)
+		}
+		if fields[0] == "0" || strings.Trim(fields[1], "0") == "" {
+			if !verifyPath(path) {
+				return fmt.Errorf("%s: invalid path", path)
+			}
+			activeCache.RemoveCacheEntry(path)
+			continue
+		}
+		if err := addCacheInfo(fields[0], fields[1], path, stage); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
 func main() {
 	var err error
-	targetPaths := os.Args[1:]
 	activeCache, err = cache.ReadCache()
 	if err != nil {
 		panic(err)
@@ -86,13 +250,66 @@ func main() {
 		panic(err)
 	}
 	defer newIndexFile.Close()
-	for _, path := range targetPaths {
-		if !verifyPath(path) {
+	fail := func(err error) {
+		os.Remove(tmpIndexFilePath)
+		log.Fatal("unable to update cache: ", err)
+	}
+	args := os.Args[1:]
+	forceRemove := false
+	endOfOptions := false
+	needsUpdate := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !endOfOptions && strings.HasPrefix(arg, "--") {
+			switch arg {
+			case "--":
+				endOfOptions = true
+			case "--refresh":
+				changed, err := refreshCache()
+				if err != nil {
+					fail(err)
+				}
+				needsUpdate = needsUpdate || changed
+			case "--add":
+				allowAdd = true
+			case "--remove":
+				allowRemove = true
+			case "--force-remove":
+				forceRemove = true
+			case "--cacheinfo":
+				// "mode,sha1,path"の1引数か、昔ながらの3引数
+				var parts []string
+				if i+1 < len(args) && strings.Count(args[i+1], ",") >= 2 {
+					parts = strings.SplitN(args[i+1], ",", 3)
+					i++
+				} else if i+3 < len(args) {
+					parts = args[i+1 : i+4]
+					i += 3
+				} else {
+					fail(errors.New(USAGE))
+				}
+				if err := addCacheInfo(parts[0], parts[1], parts[2], 0); err != nil {
+					fail(err)
+				}
+			case "--index-info":
+				if err := applyIndexInfo(os.Stdin); err != nil {
+					fail(err)
+				}
+			default:
+				fail(errors.New(USAGE))
+			}
+			continue
+		}
+		if !verifyPath(arg) {
+			fmt.Fprintf(os.Stderr, "Ignoring path %s\n", arg)
+			continue
+		}
+		if forceRemove {
+			activeCache.RemoveCacheEntry(arg)
 			continue
 		}
-		if err := addFileToCache(path); err != nil {
-			os.Remove(tmpIndexFilePath)
-			log.Fatal("unable to add file to cache: ", err)
+		if err := addFileToCache(arg); err != nil {
+			fail(err)
 		}
 	}
 	err = activeCache.WriteCache(newIndexFile)
@@ -100,6 +317,10 @@ func main() {
 		os.Remove(tmpIndexFilePath)
 		log.Fatal("unable to write cache: ", err)
 	}
-	renameIndexFile()
-
+	if err := durable.CommitFile(newIndexFile, fmt.Sprintf("%s/index", env.GetSHA1FileDirectory()), config.FSYNC_INDEX); err != nil {
+		log.Fatal("unable to write cache: ", err)
+	}
+	if needsUpdate {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/refs"
+)
+
+var USAGE = "update-ref <ref> <sha1> | update-ref -d <ref>"
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatal(USAGE)
+	}
+	if os.Args[1] == "-d" {
+		if err := refs.DeleteRef(os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	sha1, err := objects.ResolveSha1Hex(os.Args[2])
+	if err != nil {
+		log.Fatal(USAGE)
+	}
+	if !objects.HasSha1File(sha1) {
+		log.Fatalf("%x: %v", sha1, objects.ErrObjectNotFound)
+	}
+	if err := refs.UpdateRef(os.Args[1], sha1); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -6,15 +6,12 @@ import (
 	"os"
 
 	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/object"
 	"github.com/marutaku/go-git/internal/objects"
 )
 
-var ORIG_OFFSET = 40
-
 func checkValidSha1(sha1Hash []byte) bool {
-	fileName := objects.GetSha1FileName(sha1Hash)
-	_, err := os.Stat(fileName)
-	return err == nil
+	return objects.HasSha1File(sha1Hash)
 }
 
 func main() {
@@ -25,31 +22,31 @@ func main() {
 	if len(entries) == 0 {
 		log.Fatal("No file-cache to create a tree of \n")
 	}
-	size := len(entries)*40 + 400
-	offset := ORIG_OFFSET
-	treeBuffer := make([]byte, size)
+	// 衝突中のエントリはツリーに書けない
+	unmerged := false
+	for _, entry := range entries {
+		if entry.Stage() != 0 {
+			fmt.Fprintf(os.Stderr, "%s: unmerged (%x)\n", entry.Name, entry.Sha1)
+			unmerged = true
+		}
+	}
+	if unmerged {
+		log.Fatal("write-tree: error building trees")
+	}
+	tree := &object.Tree{}
 	for _, entry := range entries {
 		if !checkValidSha1(entry.Sha1) {
 			log.Fatalf("Invalid sha1: %x\n", entry.Sha1)
 		}
-		requiredSpace := offset + int(entry.NameLen) + 60
-		if requiredSpace > size {
-			size = ((requiredSpace) + 16) * 3 / 2
-			treeBuffer = append(treeBuffer, make([]byte, size-len(treeBuffer))...)
-		}
-		contentBytes := []byte(fmt.Sprintf("%o %s", entry.STMode, entry.Name))
-		copy(treeBuffer[offset:], contentBytes)
-		offset += len(contentBytes)
-		offset++
-		treeBuffer[offset] = byte(0)
-		copy(treeBuffer[offset:], entry.Sha1)
-		offset += 20
+		tree.Entries = append(tree.Entries, &object.TreeEntry{
+			Mode: entry.STMode,
+			Name: entry.Name,
+			Sha1: entry.Sha1,
+		})
 	}
-	i := objects.PrependInteger(treeBuffer, offset-ORIG_OFFSET, ORIG_OFFSET)
-	i -= 5
-	copy(treeBuffer[i:], []byte("tree "))
-	err = objects.WriteSha1File(treeBuffer[i:offset])
+	sha1, err := object.Write(tree)
 	if err != nil {
 		log.Fatal("Failed to write tree: ", err)
 	}
+	fmt.Printf("%x\n", sha1)
 }
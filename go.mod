@@ -1,3 +1,5 @@
 module github.com/marutaku/go-git
 
 go 1.22.0
+
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,476 @@
+// Package bitmap reads and writes Git's reachability bitmaps (.bitmap
+// files). For selected commits a bitmap records every object of the pack
+// reachable from them, so what a set of tips reaches is mostly a matter of
+// OR-ing bitmaps instead of inflating commits and trees.
+package bitmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/ewah"
+	"github.com/marutaku/go-git/internal/hash"
+	gitobject "github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/pack"
+)
+
+var BITMAP_SIGNATURE = []byte("BITM")
+
+const BITMAP_VERSION = 1
+
+// BITMAP_OPT_FULL_DAG says every bitmap covers the full closure of its
+// commit; it is the only kind this package writes.
+const BITMAP_OPT_FULL_DAG = 0x1
+
+const BITMAP_HEADER_SIZE = 12
+
+// SELECT_EVERY is how many commits apart bitmaps are stored along history,
+// on top of the one for each tip.
+var SELECT_EVERY = 100
+
+// FileName returns the bitmap belonging to p.
+func FileName(p *pack.Packfile) string {
+	return p.Name + ".bitmap"
+}
+
+// Index is a loaded .bitmap. Bit i stands for the i-th object of the pack in
+// offset order.
+type Index struct {
+	Pack *pack.Packfile
+	// order maps bit positions to .idx positions, position the reverse.
+	order    []int
+	position []int
+	Commits  *ewah.Bitmap
+	Trees    *ewah.Bitmap
+	Blobs    *ewah.Bitmap
+	Tags     *ewah.Bitmap
+	entries  map[string]*ewah.Bitmap
+}
+
+func newIndex(p *pack.Packfile) *Index {
+	order := p.Index.PackOrder()
+	position := make([]int, len(order))
+	for bit, i := range order {
+		position[i] = bit
+	}
+	return &Index{
+		Pack:     p,
+		order:    order,
+		position: position,
+		Commits:  ewah.New(),
+		Trees:    ewah.New(),
+		Blobs:    ewah.New(),
+		Tags:     ewah.New(),
+		entries:  map[string]*ewah.Bitmap{},
+	}
+}
+
+// Position returns the bit standing for sha1.
+func (idx *Index) Position(sha1 []byte) (int, bool) {
+	i, ok := idx.Pack.Index.FindIndex(sha1)
+	if !ok {
+		return 0, false
+	}
+	return idx.position[i], true
+}
+
+// Sha1 returns the object bit stands for.
+func (idx *Index) Sha1(bit int) []byte {
+	return idx.Pack.Index.Sha1(idx.order[bit])
+}
+
+// Count returns how many commits have a stored bitmap.
+func (idx *Index) Count() int {
+	return len(idx.entries)
+}
+
+// Lookup returns the stored bitmap of commit sha1, or nil.
+func (idx *Index) Lookup(sha1 []byte) *ewah.Bitmap {
+	return idx.entries[string(sha1)]
+}
+
+// TypeOf returns the type of the object at bit.
+func (idx *Index) TypeOf(bit int) string {
+	switch {
+	case idx.Commits.Get(bit):
+		return "commit"
+	case idx.Trees.Get(bit):
+		return "tree"
+	case idx.Blobs.Get(bit):
+		return "blob"
+	case idx.Tags.Get(bit):
+		return "tag"
+	}
+	return ""
+}
+
+// Load reads the bitmap of p. It returns an error wrapping os.ErrNotExist
+// when the pack has none.
+func Load(p *pack.Packfile) (*Index, error) {
+	data, err := os.ReadFile(FileName(p))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parse(p, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName(p), err)
+	}
+	return idx, nil
+}
+
+// Open loads the bitmap of the first of packs that has one, or returns nil
+// when none does. Like Git, only one bitmapped pack is used.
+func Open(packs []*pack.Packfile) (*Index, error) {
+	for _, p := range packs {
+		idx, err := Load(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		return idx, err
+	}
+	return nil, nil
+}
+
+func parse(p *pack.Packfile, data []byte) (*Index, error) {
+	hashSize := hash.Size()
+	if len(data) < BITMAP_HEADER_SIZE+2*hashSize {
+		return nil, errors.New("bitmap too small")
+	}
+	if !bytes.Equal(data[:4], BITMAP_SIGNATURE) {
+		return nil, errors.New("bad bitmap signature")
+	}
+	if version := binary.BigEndian.Uint16(data[4:6]); version != BITMAP_VERSION {
+		return nil, fmt.Errorf("unsupported bitmap version %d", version)
+	}
+	if binary.BigEndian.Uint16(data[6:8])&BITMAP_OPT_FULL_DAG == 0 {
+		return nil, errors.New("bitmap does not cover full closures")
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	if !bytes.Equal(data[12:12+hashSize], p.Index.PackChecksum) {
+		return nil, errors.New("bitmap belongs to a different pack")
+	}
+	h := hash.New()
+	h.Write(data[:len(data)-hashSize])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-hashSize:]) {
+		return nil, errors.New("bitmap checksum mismatch")
+	}
+	body := data[BITMAP_HEADER_SIZE+hashSize : len(data)-hashSize]
+	idx := newIndex(p)
+	for _, typeBitmap := range []**ewah.Bitmap{&idx.Commits, &idx.Trees, &idx.Blobs, &idx.Tags} {
+		b, n, err := ewah.Read(body)
+		if err != nil {
+			return nil, err
+		}
+		*typeBitmap = b
+		body = body[n:]
+	}
+	// xorで前のエントリとの差分として保存されている場合もある
+	var stored []*ewah.Bitmap
+	for i := 0; i < count; i++ {
+		if len(body) < 6 {
+			return nil, errors.New("bitmap entries truncated")
+		}
+		position := int(binary.BigEndian.Uint32(body[0:4]))
+		xorOffset := int(body[4])
+		b, n, err := ewah.Read(body[6:])
+		if err != nil {
+			return nil, err
+		}
+		body = body[6+n:]
+		if position >= p.Index.Count() {
+			return nil, errors.New("bitmap entry for an object outside the pack")
+		}
+		if xorOffset > 0 {
+			if xorOffset > len(stored) {
+				return nil, errors.New("bitmap entry xor offset out of range")
+			}
+			b.Xor(stored[len(stored)-xorOffset])
+		}
+		stored = append(stored, b)
+		idx.entries[string(p.Index.Sha1(position))] = b
+	}
+	return idx, nil
+}
+
+// Reachable calls fn once with every object reachable from roots. Stored
+// bitmaps are OR-ed in where a walk meets a selected commit; read is used to
+// inflate the commits, trees and tags on the way there, including objects
+// outside the pack.
+func (idx *Index) Reachable(roots [][]byte, read func(sha1 []byte) (string, []byte, error), fn func(sha1 []byte) error) error {
+	result := ewah.New()
+	seen := map[string]bool{}
+	type item struct {
+		sha1    []byte
+		objType string
+	}
+	var stack []item
+	for _, root := range roots {
+		stack = append(stack, item{sha1: root})
+	}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		bit, inPack := idx.Position(current.sha1)
+		if inPack {
+			if result.Get(bit) {
+				continue
+			}
+			if b := idx.Lookup(current.sha1); b != nil {
+				result.Or(b)
+				continue
+			}
+			result.Set(bit)
+			current.objType = idx.TypeOf(bit)
+		} else {
+			if seen[string(current.sha1)] {
+				continue
+			}
+			seen[string(current.sha1)] = true
+			if err := fn(current.sha1); err != nil {
+				return err
+			}
+		}
+		// パック外のblobは存在を確かめるためだけに読む
+		if current.objType == "blob" && inPack {
+			continue
+		}
+		nodeType, body, err := read(current.sha1)
+		if err != nil {
+			return err
+		}
+		children, err := links(nodeType, body)
+		if err != nil {
+			return fmt.Errorf("%s %x: %w", nodeType, current.sha1, err)
+		}
+		for _, child := range children {
+			stack = append(stack, item{sha1: child.sha1, objType: child.objType})
+		}
+	}
+	var err error
+	result.ForEach(func(bit int) {
+		if err == nil {
+			err = fn(idx.Sha1(bit))
+		}
+	})
+	return err
+}
+
+type link struct {
+	sha1    []byte
+	objType string
+}
+
+// links returns what an object points at. Blobs are typed so that they need
+// not be inflated.
+func links(nodeType string, body []byte) ([]link, error) {
+	var children []link
+	switch nodeType {
+	case "commit":
+		commit, err := gitobject.ParseCommit(body)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, link{sha1: commit.Tree, objType: "tree"})
+		for _, parent := range commit.Parents {
+			children = append(children, link{sha1: parent, objType: "commit"})
+		}
+	case "tag":
+		tag, err := gitobject.ParseTag(body)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, link{sha1: tag.Object, objType: tag.TargetType})
+	case "tree":
+		tree, err := gitobject.ParseTree(body)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range tree.Entries {
+			switch {
+			case entry.IsGitlink():
+			case entry.IsTree():
+				children = append(children, link{sha1: entry.Sha1, objType: "tree"})
+			default:
+				children = append(children, link{sha1: entry.Sha1, objType: "blob"})
+			}
+		}
+	}
+	return children, nil
+}
+
+// Write stores bitmaps for p: one for every commit in tips and one every
+// SELECT_EVERY commits along their history. Commits whose closure is not
+// entirely in the pack get none. types gives the type of every packed
+// object, keyed by raw object ID.
+func Write(p *pack.Packfile, types map[string]string, tips [][]byte) (int, error) {
+	idx := newIndex(p)
+	count := p.Index.Count()
+	// まずパック内のグラフを一度だけ読んで、ビット位置で持つ
+	children := make([][]int, count)
+	incomplete := make([]bool, count)
+	for bit := 0; bit < count; bit++ {
+		sha1 := idx.Sha1(bit)
+		objType := types[string(sha1)]
+		switch objType {
+		case "commit":
+			idx.Commits.Set(bit)
+		case "tree":
+			idx.Trees.Set(bit)
+		case "blob":
+			idx.Blobs.Set(bit)
+			continue
+		case "tag":
+			idx.Tags.Set(bit)
+		default:
+			return 0, fmt.Errorf("%x: unknown object type %q", sha1, objType)
+		}
+		_, body, err := p.ReadAt(p.Index.Offset(idx.order[bit]))
+		if err != nil {
+			return 0, err
+		}
+		objectLinks, err := links(objType, body)
+		if err != nil {
+			return 0, fmt.Errorf("%s %x: %w", objType, sha1, err)
+		}
+		for _, child := range objectLinks {
+			childBit, ok := idx.Position(child.sha1)
+			if !ok {
+				incomplete[bit] = true
+				continue
+			}
+			children[bit] = append(children[bit], childBit)
+		}
+	}
+
+	// 祖先が先に来る順に並べ、タグは指すコミットに置き換える
+	var history []int
+	visited := make([]bool, count)
+	isTip := map[int]bool{}
+	for _, tip := range tips {
+		bit, ok := idx.Position(tip)
+		for ok && idx.Tags.Get(bit) && len(children[bit]) == 1 {
+			bit = children[bit][0]
+		}
+		if !ok || !idx.Commits.Get(bit) {
+			continue
+		}
+		isTip[bit] = true
+		type frame struct {
+			bit  int
+			next int
+		}
+		stack := []frame{{bit: bit}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next == 0 {
+				if visited[top.bit] {
+					stack = stack[:len(stack)-1]
+					continue
+				}
+				visited[top.bit] = true
+			}
+			pushed := false
+			for top.next < len(children[top.bit]) {
+				child := children[top.bit][top.next]
+				top.next++
+				if idx.Commits.Get(child) && !visited[child] {
+					stack = append(stack, frame{bit: child})
+					pushed = true
+					break
+				}
+			}
+			if !pushed {
+				history = append(history, top.bit)
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	var selected []int
+	for n, bit := range history {
+		if isTip[bit] || (n+1)%SELECT_EVERY == 0 {
+			selected = append(selected, bit)
+		}
+	}
+	computed := map[int]*ewah.Bitmap{}
+	var written []int
+	for _, commit := range selected {
+		closure := ewah.New()
+		complete := true
+		stack := []int{commit}
+		for len(stack) > 0 && complete {
+			bit := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if closure.Get(bit) {
+				continue
+			}
+			if b, ok := computed[bit]; ok {
+				closure.Or(b)
+				continue
+			}
+			if incomplete[bit] {
+				complete = false
+				break
+			}
+			closure.Set(bit)
+			stack = append(stack, children[bit]...)
+		}
+		if !complete {
+			continue
+		}
+		computed[commit] = closure
+		written = append(written, commit)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(p.Name), "tmp_bitmap_")
+	if err != nil {
+		return 0, err
+	}
+	writer := bufio.NewWriter(file)
+	h := hash.New()
+	out := io.MultiWriter(writer, h)
+	header := append([]byte(nil), BITMAP_SIGNATURE...)
+	header = binary.BigEndian.AppendUint16(header, BITMAP_VERSION)
+	header = binary.BigEndian.AppendUint16(header, BITMAP_OPT_FULL_DAG)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(written)))
+	header = append(header, p.Index.PackChecksum...)
+	_, err = out.Write(header)
+	for _, typeBitmap := range []*ewah.Bitmap{idx.Commits, idx.Trees, idx.Blobs, idx.Tags} {
+		if err == nil {
+			_, err = typeBitmap.WriteTo(out)
+		}
+	}
+	for _, commit := range written {
+		if err != nil {
+			break
+		}
+		entry := binary.BigEndian.AppendUint32(nil, uint32(idx.order[commit]))
+		entry = append(entry, 0, 0)
+		if _, err = out.Write(entry); err == nil {
+			_, err = computed[commit].WriteTo(out)
+		}
+	}
+	if err == nil {
+		_, err = writer.Write(h.Sum(nil))
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if err := durable.CommitFile(file, FileName(p), config.FSYNC_PACK); err != nil {
+		return 0, err
+	}
+	return len(written), nil
+}
@@ -0,0 +1,209 @@
+package bitmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/marutaku/go-git/internal/hash"
+	gitobject "github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/pack"
+)
+
+type testObject struct {
+	sha1    []byte
+	objType string
+	body    []byte
+}
+
+func newTestObject(objType string, body []byte) *testObject {
+	h := hash.New()
+	fmt.Fprintf(h, "%s %d\x00", objType, len(body))
+	h.Write(body)
+	return &testObject{sha1: h.Sum(nil), objType: objType, body: body}
+}
+
+func newTestCommit(tree *testObject, parents ...[]byte) *testObject {
+	signature := &gitobject.Signature{Name: "A U Thor", Email: "author@example.com", When: time.Unix(1700000000, 0).UTC()}
+	commit := &gitobject.Commit{Tree: tree.sha1, Parents: parents, Author: signature, Committer: signature, Message: "message\n"}
+	return newTestObject("commit", commit.Encode())
+}
+
+// writeTestPack packs objects into dir and opens the pack.
+func writeTestPack(t *testing.T, dir string, objects []*testObject) *pack.Packfile {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer, err := pack.NewWriter(&buffer, uint32(len(objects)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		objType, err := pack.TypeFromName(object.objType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteObject(object.sha1, objType, int64(len(object.body)), bytes.NewReader(object.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksum, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("pack-%x", checksum))
+	if err := os.WriteFile(name+".pack", buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var idx bytes.Buffer
+	if err := pack.WriteIndex(&idx, writer.Entries(), checksum); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name+".idx", idx.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := pack.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func hexIDs(objects ...*testObject) []string {
+	var ids []string
+	for _, object := range objects {
+		ids = append(ids, fmt.Sprintf("%x", object.sha1))
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestWriteAndLoad(t *testing.T) {
+	one := newTestObject("blob", []byte("one\n"))
+	two := newTestObject("blob", []byte("two\n"))
+	firstTree := newTestObject("tree", (&gitobject.Tree{Entries: []*gitobject.TreeEntry{
+		{Mode: 0100644, Name: "a", Sha1: one.sha1},
+	}}).Encode())
+	secondTree := newTestObject("tree", (&gitobject.Tree{Entries: []*gitobject.TreeEntry{
+		{Mode: 0100644, Name: "a", Sha1: one.sha1},
+		{Mode: 0100644, Name: "b", Sha1: two.sha1},
+	}}).Encode())
+	first := newTestCommit(firstTree)
+	second := newTestCommit(secondTree, first.sha1)
+	// 親がパックに無いコミットにはビットマップを作れない
+	orphan := newTestCommit(firstTree, bytes.Repeat([]byte{0xee}, hash.Size()))
+	objects := []*testObject{first, second, orphan, firstTree, secondTree, one, two}
+
+	p := writeTestPack(t, t.TempDir(), objects)
+	types := map[string]string{}
+	for _, object := range objects {
+		types[string(object.sha1)] = object.objType
+	}
+	written, err := Write(p, types, [][]byte{second.sha1, orphan.sha1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 1 {
+		t.Errorf("Write stored %d bitmaps, want 1", written)
+	}
+	idx, err := Load(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Lookup(orphan.sha1) != nil {
+		t.Errorf("a bitmap was stored for a commit whose closure is outside the pack")
+	}
+	stored := idx.Lookup(second.sha1)
+	if stored == nil {
+		t.Fatal("no bitmap for the tip")
+	}
+	var closure []string
+	stored.ForEach(func(bit int) { closure = append(closure, fmt.Sprintf("%x", idx.Sha1(bit))) })
+	slices.Sort(closure)
+	if want := hexIDs(first, second, firstTree, secondTree, one, two); !slices.Equal(closure, want) {
+		t.Errorf("closure = %v, want %v", closure, want)
+	}
+	for _, object := range objects {
+		bit, ok := idx.Position(object.sha1)
+		if !ok {
+			t.Fatalf("%x has no bit", object.sha1)
+		}
+		if got := idx.TypeOf(bit); got != object.objType {
+			t.Errorf("%x: TypeOf = %q, want %q", object.sha1, got, object.objType)
+		}
+	}
+
+	read := func(sha1 []byte) (string, []byte, error) {
+		for _, object := range objects {
+			if bytes.Equal(object.sha1, sha1) {
+				return object.objType, object.body, nil
+			}
+		}
+		return "", nil, errors.New("not found")
+	}
+	var reached []string
+	err = idx.Reachable([][]byte{second.sha1}, read, func(sha1 []byte) error {
+		reached = append(reached, fmt.Sprintf("%x", sha1))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(reached)
+	if !slices.Equal(reached, closure) {
+		t.Errorf("Reachable = %v, want %v", reached, closure)
+	}
+}
+
+func TestLoadRejectsBadBitmaps(t *testing.T) {
+	blob := newTestObject("blob", []byte("blob\n"))
+	tree := newTestObject("tree", (&gitobject.Tree{Entries: []*gitobject.TreeEntry{
+		{Mode: 0100644, Name: "blob", Sha1: blob.sha1},
+	}}).Encode())
+	commit := newTestCommit(tree)
+	objects := []*testObject{commit, tree, blob}
+	p := writeTestPack(t, t.TempDir(), objects)
+	types := map[string]string{}
+	for _, object := range objects {
+		types[string(object.sha1)] = object.objType
+	}
+	if _, err := Write(p, types, [][]byte{commit.sha1}); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := os.ReadFile(FileName(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"bad signature", 0},
+		{"bad version", 5},
+		{"other pack", 12},
+		{"bad checksum", len(valid) - 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append([]byte(nil), valid...)
+			data[test.offset] ^= 0xff
+			if err := os.WriteFile(FileName(p), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(p); err == nil {
+				t.Errorf("Load accepted a corrupt bitmap")
+			}
+		})
+	}
+	if err := os.Remove(FileName(p)); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err := Open([]*pack.Packfile{p}); idx != nil || err != nil {
+		t.Errorf("Open without a bitmap = %v, %v; want nil, nil", idx, err)
+	}
+}
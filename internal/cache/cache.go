@@ -1,36 +1,136 @@
 package cache
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
+	"slices"
+	"strings"
 	"syscall"
 
 	"github.com/marutaku/go-git/internal/cache/cachetime"
 	"github.com/marutaku/go-git/internal/env"
 	"github.com/marutaku/go-git/internal/hash"
 	objectBuffer "github.com/marutaku/go-git/internal/objects"
-	"github.com/marutaku/go-git/internal/utils"
+)
+
+// Bits of an entry's flags word. The low 12 bits hold the name length and
+// are kept in NameLen instead.
+const (
+	CE_VALID      = 0x8000
+	CE_EXTENDED   = 0x4000
+	CE_STAGEMASK  = 0x3000
+	CE_STAGESHIFT = 12
+	CE_NAMEMASK   = 0x0fff
+)
+
+// Bits of the second flags word a version 3 entry has when CE_EXTENDED is
+// set.
+const (
+	CE_SKIP_WORKTREE   = 0x4000
+	CE_INTENT_TO_ADD   = 0x2000
+	CE_EXTENDED_FLAGS  = CE_SKIP_WORKTREE | CE_INTENT_TO_ADD
+	cacheEntryStatSize = 40
 )
 
 type CacheEntry struct {
-	CTime   cachetime.CacheTime
-	MTime   cachetime.CacheTime
-	STDev   uint32
-	STIno   uint32
-	STMode  uint32
-	STUid   uint32
-	STGid   uint32
-	STSize  uint32
-	Sha1    []byte
-	NameLen uint16
-	Name    string
+	CTime         cachetime.CacheTime
+	MTime         cachetime.CacheTime
+	STDev         uint32
+	STIno         uint32
+	STMode        uint32
+	STUid         uint32
+	STGid         uint32
+	STSize        uint32
+	Sha1          []byte
+	Flags         uint16
+	ExtendedFlags uint16
+	NameLen       uint16
+	Name          string
+}
+
+// Stage is the merge stage of the entry, 0 outside of a conflict.
+func (e *CacheEntry) Stage() int {
+	return int(e.Flags&CE_STAGEMASK) >> CE_STAGESHIFT
+}
+
+// CreateCacheMode turns a file's st_mode into one of the modes Git keeps:
+// symlink, gitlink, or a regular file that is executable or not.
+func CreateCacheMode(mode uint32) uint32 {
+	switch mode & syscall.S_IFMT {
+	case syscall.S_IFLNK:
+		return syscall.S_IFLNK
+	case syscall.S_IFDIR, S_IFGITLINK:
+		return S_IFGITLINK
+	}
+	if mode&0100 != 0 {
+		return syscall.S_IFREG | 0755
+	}
+	return syscall.S_IFREG | 0644
+}
+
+// S_IFGITLINK is the mode of a submodule commit.
+const S_IFGITLINK = 0160000
+
+// entrySize is the on-disk size of an entry, padded to a multiple of 8
+// bytes with at least one NUL after the name.
+func entrySize(hashSize, nameLen int) int {
+	return (cacheEntryStatSize + hashSize + 2 + nameLen + 8) & ^7
 }
 
+func (e *CacheEntry) flagsWord() uint16 {
+	flags := e.Flags &^ (CE_NAMEMASK | CE_EXTENDED)
+	if e.ExtendedFlags&CE_EXTENDED_FLAGS != 0 {
+		flags |= CE_EXTENDED
+	}
+	return flags | uint16(min(len(e.Name), CE_NAMEMASK))
+}
+
+// encode returns the entry as a DIRC index of version stores it. Version 4
+// stores the name as the part that differs from previousName.
+func (e *CacheEntry) encode(version uint32, previousName string) []byte {
+	hashSize := len(e.Sha1)
+	flags := e.flagsWord()
+	bytes := make([]byte, 0, entrySize(hashSize, len(e.Name))+2)
+	for _, value := range []uint32{e.CTime.Sec, e.CTime.NSec, e.MTime.Sec, e.MTime.NSec, e.STDev, e.STIno, e.STMode, e.STUid, e.STGid, e.STSize} {
+		bytes = binary.BigEndian.AppendUint32(bytes, value)
+	}
+	bytes = append(bytes, e.Sha1...)
+	bytes = binary.BigEndian.AppendUint16(bytes, flags)
+	fixedSize := cacheEntryStatSize + hashSize + 2
+	if flags&CE_EXTENDED != 0 {
+		bytes = binary.BigEndian.AppendUint16(bytes, e.ExtendedFlags&CE_EXTENDED_FLAGS)
+		fixedSize += 2
+	}
+	if version >= 4 {
+		common := 0
+		for common < len(previousName) && common < len(e.Name) && previousName[common] == e.Name[common] {
+			common++
+		}
+		bytes = appendVarint(bytes, uint64(len(previousName)-common))
+		bytes = append(bytes, e.Name[common:]...)
+		return append(bytes, 0)
+	}
+	bytes = append(bytes, e.Name...)
+	padding := ((fixedSize + len(e.Name) + 8) & ^7) - len(bytes)
+	return append(bytes, make([]byte, padding)...)
+}
+
+// Bytes returns the entry as a version 2 or 3 index stores it.
 func (e *CacheEntry) Bytes() []byte {
-	size := (62 + len(e.Name) + 8) & ^7
+	return e.encode(3, "")
+}
+
+// legacyBytes returns the entry in the "CRID" format, for checking the
+// checksum of an old index.
+func (e *CacheEntry) legacyBytes() []byte {
+	hashSize := len(e.Sha1)
+	size := entrySize(hashSize, len(e.Name))
 	bytes := make([]byte, size)
 	binary.LittleEndian.PutUint32(bytes[0:], e.CTime.Sec)
 	binary.LittleEndian.PutUint32(bytes[4:], e.CTime.NSec)
@@ -43,28 +143,90 @@ func (e *CacheEntry) Bytes() []byte {
 	binary.LittleEndian.PutUint32(bytes[32:], e.STGid)
 	binary.LittleEndian.PutUint32(bytes[36:], e.STSize)
 	copy(bytes[40:], e.Sha1)
-	binary.LittleEndian.PutUint16(bytes[60:], e.NameLen)
-	copy(bytes[62:], []byte(e.Name))
+	binary.LittleEndian.PutUint16(bytes[40+hashSize:], e.NameLen)
+	copy(bytes[40+hashSize+2:], []byte(e.Name))
 	return bytes
 }
 
-func (e *CacheEntry) IndexFd(fileContent []byte, stat fs.FileInfo) error {
-	contents := []byte(fmt.Sprintf("blob %d", uint32(stat.Size())))
-	contents = append(contents, 0)
-	contents = append(contents, fileContent...)
-	compressed, err := utils.Compress(contents)
+// appendVarint appends n in the offset encoding packs and version 4 indexes
+// use: big endian groups of 7 bits where every continuation adds one.
+func appendVarint(bytes []byte, n uint64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(n & 127)
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		pos--
+		buf[pos] = 128 | byte(n&127)
+	}
+	return append(bytes, buf[pos:]...)
+}
+
+func readVarint(bytes []byte) (uint64, int, error) {
+	n := uint64(0)
+	for i, c := range bytes {
+		if i > 0 {
+			n++
+		}
+		n = n<<7 | uint64(c&127)
+		if c&128 == 0 {
+			return n, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("truncated path prefix")
+}
+
+// IndexFd streams the file contents into the object store as a blob.
+func (e *CacheEntry) IndexFd(file io.Reader, stat fs.FileInfo) error {
+	sha1, err := objectBuffer.WriteSha1Stream("blob", stat.Size(), file)
 	if err != nil {
 		return err
 	}
-	return objectBuffer.WriteSha1Buffer(e.Sha1, compressed)
+	if !bytes.Equal(sha1, e.Sha1) {
+		return fmt.Errorf("%s: file changed while it was being added", e.Name)
+	}
+	return nil
+}
+
+// linkReader hands out the target of a symlink as if it were file contents.
+type linkReader struct {
+	*strings.Reader
+}
+
+func (linkReader) Close() error {
+	return nil
 }
 
-func NewCacheEntryFromFilePath(path string, fileContents []byte) (*CacheEntry, error) {
-	fileStat, err := os.Stat(path)
+// OpenContents opens what path is stored as: the file's contents, or for a
+// symlink the path it points to, as Git stores links. stat is from Lstat,
+// so its size is the length of those contents either way.
+func OpenContents(path string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stat.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return linkReader{strings.NewReader(target)}, stat, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, stat, nil
+}
+
+// NewCacheEntryFromFilePath makes an entry for path, whose contents, as
+// OpenContents returns them, are read from file.
+func NewCacheEntryFromFilePath(path string, file io.Reader) (*CacheEntry, error) {
+	fileStat, err := os.Lstat(path)
 	if err != nil {
 		return nil, err
 	}
-	sha1, err := hash.CalculateSha1HashFromFileStat(fileStat, fileContents)
+	sha1, err := hash.CalculateSha1HashFromFileStat(fileStat, file)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +238,7 @@ func NewCacheEntryFromFilePath(path string, fileContents []byte) (*CacheEntry, e
 		MTime:   *mtime,
 		STDev:   uint32(fileStat.Sys().(*syscall.Stat_t).Dev),
 		STIno:   uint32(fileStat.Sys().(*syscall.Stat_t).Ino),
-		STMode:  uint32(fileStat.Sys().(*syscall.Stat_t).Mode),
+		STMode:  CreateCacheMode(uint32(fileStat.Sys().(*syscall.Stat_t).Mode)),
 		STUid:   uint32(fileStat.Sys().(*syscall.Stat_t).Uid),
 		STGid:   uint32(fileStat.Sys().(*syscall.Stat_t).Gid),
 		STSize:  uint32(fileStat.Size()),
@@ -87,7 +249,94 @@ func NewCacheEntryFromFilePath(path string, fileContents []byte) (*CacheEntry, e
 	return entry, nil
 }
 
-func NewCacheEntryFromBytes(indexFileBytes []byte) (*CacheEntry, uint32) {
+// NewCacheEntry makes an entry for an object already in the store, with no
+// stat data: it is never taken to match the file in the work tree.
+func NewCacheEntry(mode uint32, sha1 []byte, path string, stage int) *CacheEntry {
+	return &CacheEntry{
+		STMode:  CreateCacheMode(mode),
+		Sha1:    sha1,
+		Flags:   uint16(stage<<CE_STAGESHIFT) & CE_STAGEMASK,
+		NameLen: uint16(min(len(path), CE_NAMEMASK)),
+		Name:    path,
+	}
+}
+
+// NewCacheEntryFromBytes decodes the entry at the start of data, an index
+// of version. previousName is the name of the entry before it, which version
+// 4 names are relative to. It returns the entry and the bytes it took.
+func NewCacheEntryFromBytes(data []byte, version uint32, previousName string) (*CacheEntry, int, error) {
+	hashSize := hash.Size()
+	fixedSize := cacheEntryStatSize + hashSize + 2
+	if len(data) < fixedSize {
+		return nil, 0, errors.New("entry truncated")
+	}
+	entry := &CacheEntry{}
+	entry.CTime.Sec = binary.BigEndian.Uint32(data[0:4])
+	entry.CTime.NSec = binary.BigEndian.Uint32(data[4:8])
+	entry.MTime.Sec = binary.BigEndian.Uint32(data[8:12])
+	entry.MTime.NSec = binary.BigEndian.Uint32(data[12:16])
+	entry.STDev = binary.BigEndian.Uint32(data[16:20])
+	entry.STIno = binary.BigEndian.Uint32(data[20:24])
+	entry.STMode = binary.BigEndian.Uint32(data[24:28])
+	entry.STUid = binary.BigEndian.Uint32(data[28:32])
+	entry.STGid = binary.BigEndian.Uint32(data[32:36])
+	entry.STSize = binary.BigEndian.Uint32(data[36:40])
+	entry.Sha1 = append([]byte(nil), data[40:40+hashSize]...)
+	flags := binary.BigEndian.Uint16(data[40+hashSize:])
+	entry.Flags = flags &^ CE_NAMEMASK
+	entry.NameLen = flags & CE_NAMEMASK
+	if flags&CE_EXTENDED != 0 {
+		if version < 3 {
+			return nil, 0, errors.New("extended flags in a version 2 index")
+		}
+		if len(data) < fixedSize+2 {
+			return nil, 0, errors.New("entry truncated")
+		}
+		entry.ExtendedFlags = binary.BigEndian.Uint16(data[fixedSize:])
+		if entry.ExtendedFlags&^CE_EXTENDED_FLAGS != 0 {
+			return nil, 0, fmt.Errorf("unknown extended flags %#x", entry.ExtendedFlags)
+		}
+		entry.Flags &^= CE_EXTENDED
+		fixedSize += 2
+	}
+	rest := data[fixedSize:]
+	if version >= 4 {
+		strip, n, err := readVarint(rest)
+		if err != nil {
+			return nil, 0, err
+		}
+		if strip > uint64(len(previousName)) {
+			return nil, 0, errors.New("bad path prefix")
+		}
+		end := bytes.IndexByte(rest[n:], 0)
+		if end < 0 {
+			return nil, 0, errors.New("entry name not terminated")
+		}
+		entry.Name = previousName[:len(previousName)-int(strip)] + string(rest[n:n+end])
+		return entry, fixedSize + n + end + 1, nil
+	}
+	// 0xfffより長い名前は長さが入りきらないのでNULを探す
+	nameLen := int(entry.NameLen)
+	if nameLen == CE_NAMEMASK {
+		nameLen = bytes.IndexByte(rest, 0)
+	}
+	if nameLen < 0 || nameLen >= len(rest) {
+		return nil, 0, errors.New("entry name truncated")
+	}
+	size := (fixedSize + nameLen + 8) & ^7
+	if size > len(data) {
+		return nil, 0, errors.New("entry truncated")
+	}
+	entry.Name = string(rest[:nameLen])
+	return entry, size, nil
+}
+
+func newCacheEntryFromLegacyBytes(indexFileBytes []byte) (*CacheEntry, int, error) {
+	hashSize := hash.Size()
+	nameOffset := 40 + hashSize + 2
+	if len(indexFileBytes) < nameOffset {
+		return nil, 0, errors.New("entry truncated")
+	}
 	entry := &CacheEntry{}
 	entry.CTime.Sec = binary.LittleEndian.Uint32(indexFileBytes[:4])
 	entry.CTime.NSec = binary.LittleEndian.Uint32(indexFileBytes[4:8])
@@ -99,51 +348,157 @@ func NewCacheEntryFromBytes(indexFileBytes []byte) (*CacheEntry, uint32) {
 	entry.STUid = binary.LittleEndian.Uint32(indexFileBytes[28:32])
 	entry.STGid = binary.LittleEndian.Uint32(indexFileBytes[32:36])
 	entry.STSize = binary.LittleEndian.Uint32(indexFileBytes[36:40])
-	entry.Sha1 = indexFileBytes[40:60]
-	entry.NameLen = binary.LittleEndian.Uint16(indexFileBytes[60:62])
-	entry.Name = string(indexFileBytes[62 : 62+entry.NameLen])
-	size := (62 + len(entry.Name) + 8) & ^7
-	return entry, uint32(size)
+	entry.Sha1 = indexFileBytes[40 : 40+hashSize]
+	entry.NameLen = binary.LittleEndian.Uint16(indexFileBytes[40+hashSize : nameOffset])
+	if len(indexFileBytes) < nameOffset+int(entry.NameLen) {
+		return nil, 0, errors.New("entry name truncated")
+	}
+	entry.Name = string(indexFileBytes[nameOffset : nameOffset+int(entry.NameLen)])
+	return entry, entrySize(hashSize, len(entry.Name)), nil
 }
 
+// readVersion is the version of the index ReadCache found, so that writing
+// it back keeps the version. 0 means a new or legacy index.
+var readVersion uint32
+
 func ReadCache() (ActiveCache, error) {
+	if _, err := hash.Current(); err != nil {
+		return nil, err
+	}
 	sha1FileDir := env.GetSHA1FileDirectory()
 	if _, err := os.Stat(sha1FileDir); os.IsExist(err) {
 		return nil, errors.New("SHA1 file directory not found")
 	}
-	if _, err := os.Stat(fmt.Sprintf("%s/index", sha1FileDir)); os.IsNotExist(err) {
+	stat, err := os.Stat(fmt.Sprintf("%s/index", sha1FileDir))
+	if os.IsNotExist(err) {
 		return ActiveCache{}, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 	bytes, err := os.ReadFile(fmt.Sprintf("%s/index", sha1FileDir))
 	if err != nil {
 		return nil, err
 	}
+	indexTimestamp = *cachetime.NewMTimeFromStat(stat)
 	header, err := NewCacheHeaderFromBytes(bytes)
 	if err != nil {
 		return nil, err
 	}
-	return header.Entries, nil
+	if header.Signature == CACHE_SIGNATURE {
+		readVersion = header.Version
+	}
+	activeCache := ActiveCache(header.Entries)
+	for i := 1; i < len(activeCache); i++ {
+		if compareCacheName(activeCache[i].Name, activeCache[i].Stage(), activeCache[i-1]) <= 0 {
+			activeCache.sort()
+			break
+		}
+	}
+	return activeCache, nil
 }
 
+// ActiveCache is kept sorted the way Git sorts the index: by name, byte by
+// byte, and then by stage.
 type ActiveCache []*CacheEntry
 
+func compareCacheName(name string, stage int, entry *CacheEntry) int {
+	if c := strings.Compare(name, entry.Name); c != 0 {
+		return c
+	}
+	return stage - entry.Stage()
+}
+
+// Position returns where the entry for name and stage is, or where it would
+// be inserted, and whether it is there.
+func (ac ActiveCache) Position(name string, stage int) (int, bool) {
+	return slices.BinarySearchFunc(ac, name, func(entry *CacheEntry, name string) int {
+		return -compareCacheName(name, stage, entry)
+	})
+}
+
 func (ac ActiveCache) FindCacheEntryIndex(targetEntry *CacheEntry) int {
-	for index, entry := range ac {
-		if entry.Name == targetEntry.Name {
-			return index
-		}
+	if index, found := ac.Position(targetEntry.Name, targetEntry.Stage()); found {
+		return index
 	}
 	return -1
 }
 
+// AddCacheEntry stores entry, replacing the entry with the same name and
+// stage. A merged (stage 0) entry replaces the conflict stages of its path
+// and a conflict stage replaces the merged entry.
+func (ac *ActiveCache) AddCacheEntry(entry *CacheEntry) {
+	stage := entry.Stage()
+	index, found := ac.Position(entry.Name, stage)
+	if found {
+		(*ac)[index] = entry
+		return
+	}
+	// 同じパスの他のステージは前後に並んでいる
+	if stage == 0 {
+		end := index
+		for end < len(*ac) && (*ac)[end].Name == entry.Name {
+			end++
+		}
+		*ac = slices.Replace(*ac, index, end, entry)
+		return
+	}
+	if index > 0 && (*ac)[index-1].Name == entry.Name && (*ac)[index-1].Stage() == 0 {
+		(*ac)[index-1] = entry
+		return
+	}
+	*ac = slices.Insert(*ac, index, entry)
+}
+
+// RemoveCacheEntry drops every stage of name and reports whether there was
+// one.
+func (ac *ActiveCache) RemoveCacheEntry(name string) bool {
+	start, _ := ac.Position(name, 0)
+	end := start
+	for end < len(*ac) && (*ac)[end].Name == name {
+		end++
+	}
+	*ac = slices.Delete(*ac, start, end)
+	return end != start
+}
+
+// sort puts entries from an index written in another order, as the legacy
+// format was, into Git's order.
+func (ac ActiveCache) sort() {
+	slices.SortStableFunc(ac, func(a, b *CacheEntry) int {
+		return compareCacheName(a.Name, a.Stage(), b)
+	})
+}
+
+// WriteCache writes the entries as a DIRC index in the version the index
+// was read in, or DefaultVersion for a new one. Version 2 cannot hold
+// extended flags, so such an index is written as version 3. Racily clean
+// entries that no longer match their files are smudged first.
 func (ac ActiveCache) WriteCache(file *os.File) error {
-	// SHA1ハッシュとる箇所の自信がない
-	header := NewCacheHeader(1, ac)
-	headerBytes := header.Bytes()
-	file.Write(headerBytes)
+	version := readVersion
+	if version == 0 {
+		var err error
+		version, err = DefaultVersion()
+		if err != nil {
+			return err
+		}
+	}
+	if version == 2 {
+		for _, entry := range ac {
+			if entry.ExtendedFlags&CE_EXTENDED_FLAGS != 0 {
+				version = 3
+				break
+			}
+		}
+	}
+	// 書き直すとタイムスタンプが新しくなり、racyな判定ができなくなる
 	for _, entry := range ac {
-		entryBytes := entry.Bytes()
-		file.Write(entryBytes)
+		entry.smudgeRacilyClean()
 	}
-	return nil
+	header := NewCacheHeader(version, ac)
+	writer := bufio.NewWriter(file)
+	if _, err := header.WriteTo(writer); err != nil {
+		return err
+	}
+	return writer.Flush()
 }
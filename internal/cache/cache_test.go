@@ -0,0 +1,360 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/marutaku/go-git/internal/cache/cachetime"
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+func testEntry(name string, stage int, sha1Byte byte) *CacheEntry {
+	entry := NewCacheEntry(0100644, bytes.Repeat([]byte{sha1Byte}, 20), name, stage)
+	entry.CTime = cachetime.CacheTime{Sec: 1700000000, NSec: 1}
+	entry.MTime = cachetime.CacheTime{Sec: 1700000001, NSec: 2}
+	entry.STDev, entry.STIno, entry.STUid, entry.STGid, entry.STSize = 3, 4, 5, 6, 7
+	return entry
+}
+
+func encodeIndex(t *testing.T, version uint32, entries []*CacheEntry) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	if _, err := NewCacheHeader(version, entries).WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	longName := strings.Repeat("d/", CE_NAMEMASK/2) + "long"
+	intentToAdd := testEntry("new", 0, 0x44)
+	intentToAdd.ExtendedFlags = CE_INTENT_TO_ADD
+	tests := []struct {
+		name    string
+		version uint32
+		entries []*CacheEntry
+	}{
+		{"empty v2", 2, nil},
+		{"v2", 2, []*CacheEntry{testEntry("a", 0, 1), testEntry("b/c", 0, 2), testEntry("b/d", 0, 3)}},
+		{"v2 stages", 2, []*CacheEntry{testEntry("conflict", 1, 1), testEntry("conflict", 2, 2), testEntry("conflict", 3, 3)}},
+		{"v2 long name", 2, []*CacheEntry{testEntry(longName, 0, 1)}},
+		{"v3 extended flags", 3, []*CacheEntry{testEntry("a", 0, 1), intentToAdd}},
+		{"v4 shared prefixes", 4, []*CacheEntry{testEntry("dir/a", 0, 1), testEntry("dir/ab", 0, 2), testEntry("dir/b", 0, 3), testEntry("other", 0, 4)}},
+		{"v4 extended flags", 4, []*CacheEntry{intentToAdd, testEntry("new2", 0, 5)}},
+		{"v4 long name", 4, []*CacheEntry{testEntry("a", 0, 1), testEntry(longName, 0, 2)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := encodeIndex(t, test.version, test.entries)
+			if test.version != 4 {
+				// version 4以外のエントリは8バイト境界に揃う
+				if (len(data)-cacheHeaderSize-hash.Size())%8 != 0 {
+					t.Errorf("entries take %d bytes, not a multiple of 8", len(data)-cacheHeaderSize-hash.Size())
+				}
+			}
+			header, err := NewCacheHeaderFromBytes(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Version != test.version {
+				t.Errorf("Version = %d, want %d", header.Version, test.version)
+			}
+			if len(header.Entries) != len(test.entries) {
+				t.Fatalf("read %d entries, want %d", len(header.Entries), len(test.entries))
+			}
+			for i, want := range test.entries {
+				got := header.Entries[i]
+				if got.Name != want.Name || !bytes.Equal(got.Sha1, want.Sha1) || got.Stage() != want.Stage() ||
+					got.ExtendedFlags != want.ExtendedFlags || got.CTime != want.CTime || got.MTime != want.MTime ||
+					got.STMode != want.STMode || got.STIno != want.STIno || got.STSize != want.STSize {
+					t.Errorf("entry %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if again := encodeIndex(t, test.version, header.Entries); !bytes.Equal(again, data) {
+				t.Errorf("writing the index back changed it")
+			}
+		})
+	}
+}
+
+func TestReadIndexChecks(t *testing.T) {
+	valid := encodeIndex(t, 2, []*CacheEntry{testEntry("a", 0, 1)})
+	end := len(valid) - hash.Size()
+	withExtension := func(signature string) []byte {
+		data := append([]byte(nil), valid[:end]...)
+		data = append(data, signature...)
+		data = binary.BigEndian.AppendUint32(data, 4)
+		data = append(data, "data"...)
+		checksum := hash.New()
+		checksum.Write(data)
+		return checksum.Sum(data)
+	}
+	skipHash := append(append([]byte(nil), valid[:end]...), make([]byte, hash.Size())...)
+	badChecksum := append([]byte(nil), valid...)
+	badChecksum[len(badChecksum)-1] ^= 0xff
+	extendedInV2 := encodeIndex(t, 3, []*CacheEntry{func() *CacheEntry {
+		entry := testEntry("a", 0, 1)
+		entry.ExtendedFlags = CE_SKIP_WORKTREE
+		return entry
+	}()})
+	binary.BigEndian.PutUint32(extendedInV2[4:8], 2)
+	checksum := hash.New()
+	checksum.Write(extendedInV2[:len(extendedInV2)-hash.Size()])
+	copy(extendedInV2[len(extendedInV2)-hash.Size():], checksum.Sum(nil))
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"valid", valid, true},
+		{"skipped checksum", skipHash, true},
+		{"optional extension", withExtension("TREE"), true},
+		{"required extension", withExtension("link"), false},
+		{"bad checksum", badChecksum, false},
+		{"too small", valid[:8], false},
+		{"bad signature", append([]byte("XXXX"), valid[4:]...), false},
+		{"version 5", append(append([]byte("DIRC"), 0, 0, 0, 5), valid[8:]...), false},
+		{"extended flags in version 2", extendedInV2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewCacheHeaderFromBytes(test.data)
+			if (err == nil) != test.ok {
+				t.Errorf("NewCacheHeaderFromBytes = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
+
+func TestReadLegacyIndex(t *testing.T) {
+	entries := []*CacheEntry{testEntry("b", 0, 2), testEntry("a", 0, 1)}
+	for _, entry := range entries {
+		entry.NameLen = uint16(len(entry.Name))
+	}
+	header := &CacheHeader{Signature: LEGACY_CACHE_SIGNATURE, Version: 1, Entries: entries}
+	data := append([]byte(nil), LEGACY_CACHE_SIGNATURE...)
+	data = binary.LittleEndian.AppendUint32(data, 1)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(entries)))
+	data = append(data, header.Sha1Hash()...)
+	for _, entry := range entries {
+		data = append(data, entry.legacyBytes()...)
+	}
+	read, err := NewCacheHeaderFromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Entries) != 2 || read.Entries[0].Name != "b" || read.Entries[1].Name != "a" {
+		t.Errorf("read %+v", read.Entries)
+	}
+	data[cacheHeaderSize] ^= 0xff
+	if _, err := NewCacheHeaderFromBytes(data); err == nil {
+		t.Errorf("a legacy index with a bad checksum was accepted")
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 255, 16383, 16384, 1 << 32, 1<<63 - 1} {
+		encoded := appendVarint(nil, n)
+		decoded, size, err := readVarint(append(encoded, 0xaa))
+		if err != nil || decoded != n || size != len(encoded) {
+			t.Errorf("varint %d: read %d, %d bytes, %v", n, decoded, size, err)
+		}
+	}
+	if _, _, err := readVarint([]byte{0x80}); err == nil {
+		t.Errorf("a truncated varint was accepted")
+	}
+}
+
+func cacheNames(ac ActiveCache) []string {
+	var names []string
+	for _, entry := range ac {
+		names = append(names, entry.Name+":"+string(rune('0'+entry.Stage())))
+	}
+	return names
+}
+
+func TestActiveCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    []*CacheEntry
+		remove string
+		want   string
+	}{
+		{"sorted by name", []*CacheEntry{testEntry("b", 0, 1), testEntry("a", 0, 1), testEntry("a/b", 0, 1), testEntry("a-b", 0, 1)}, "", "a:0 a-b:0 a/b:0 b:0"},
+		{"replaces the same stage", []*CacheEntry{testEntry("a", 0, 1), testEntry("a", 0, 2)}, "", "a:0"},
+		{"conflict replaces merged", []*CacheEntry{testEntry("a", 0, 1), testEntry("a", 2, 2), testEntry("a", 3, 3), testEntry("a", 1, 1)}, "", "a:1 a:2 a:3"},
+		{"merged replaces conflict", []*CacheEntry{testEntry("a", 1, 1), testEntry("a", 3, 3), testEntry("b", 0, 1), testEntry("a", 0, 2)}, "", "a:0 b:0"},
+		{"remove drops every stage", []*CacheEntry{testEntry("a", 1, 1), testEntry("a", 2, 2), testEntry("b", 0, 1)}, "a", "b:0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ac ActiveCache
+			for _, entry := range test.add {
+				ac.AddCacheEntry(entry)
+			}
+			if test.remove != "" && !ac.RemoveCacheEntry(test.remove) {
+				t.Errorf("RemoveCacheEntry(%q) found nothing", test.remove)
+			}
+			if got := strings.Join(cacheNames(ac), " "); got != test.want {
+				t.Errorf("cache = %q, want %q", got, test.want)
+			}
+			for i, entry := range ac {
+				if index := ac.FindCacheEntryIndex(entry); index != i {
+					t.Errorf("FindCacheEntryIndex(%s) = %d, want %d", entry.Name, index, i)
+				}
+			}
+		})
+	}
+}
+
+// stagedFile writes contents to a new file and returns an entry for it.
+func stagedFile(t *testing.T, contents string) *CacheEntry {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, _, err := OpenContents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entry, err := NewCacheEntryFromFilePath(path, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry
+}
+
+func TestRacyEntries(t *testing.T) {
+	defer func(saved cachetime.CacheTime) { indexTimestamp = saved }(indexTimestamp)
+	tests := []struct {
+		name string
+		// index is the index mtime relative to the entry's mtime, in
+		// seconds; nil means no index was read.
+		index       *int64
+		stale       bool
+		racy        bool
+		changed     bool
+		smudgedSize bool
+	}{
+		{name: "no index", index: nil, stale: true},
+		{name: "index written later", index: ptr(1), stale: true},
+		{name: "same second", index: ptr(0), racy: true},
+		{name: "same second, stale contents", index: ptr(0), stale: true, racy: true, changed: true, smudgedSize: true},
+		{name: "index older than the file", index: ptr(-1), stale: true, racy: true, changed: true, smudgedSize: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := stagedFile(t, "contents\n")
+			indexTimestamp = cachetime.CacheTime{}
+			if test.index != nil {
+				indexTimestamp = cachetime.CacheTime{Sec: uint32(int64(entry.MTime.Sec) + *test.index), NSec: entry.MTime.NSec}
+			}
+			if test.stale {
+				// statは同じまま内容だけが違うエントリ
+				entry.Sha1 = bytes.Repeat([]byte{0x11}, len(entry.Sha1))
+			}
+			if got := entry.IsRacy(); got != test.racy {
+				t.Errorf("IsRacy = %v, want %v", got, test.racy)
+			}
+			stat, err := os.Lstat(entry.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entry.MatchStat(stat); got != 0 {
+				t.Errorf("MatchStat = %#x, want 0", got)
+			}
+			changed, err := entry.Changed(stat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := changed&DATA_CHANGED != 0; got != test.changed {
+				t.Errorf("Changed = %#x, want data changed %v", changed, test.changed)
+			}
+			entry.smudgeRacilyClean()
+			if got := entry.STSize == 0; got != test.smudgedSize {
+				t.Errorf("size after smudging = %d, want smudged %v", entry.STSize, test.smudgedSize)
+			}
+		})
+	}
+}
+
+func ptr(n int64) *int64 {
+	return &n
+}
+
+func TestSmudgedEntriesAreCompared(t *testing.T) {
+	defer func(saved cachetime.CacheTime) { indexTimestamp = saved }(indexTimestamp)
+	indexTimestamp = cachetime.CacheTime{}
+	entry := stagedFile(t, "contents\n")
+	stat, err := os.Lstat(entry.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.STSize = 0
+	changed, err := entry.Changed(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != DATA_CHANGED {
+		t.Errorf("Changed = %#x, want only DATA_CHANGED from the smudged size", changed)
+	}
+}
+
+func TestMatchStat(t *testing.T) {
+	entry := stagedFile(t, "contents\n")
+	if err := os.WriteFile(entry.Name, []byte("longer contents\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(entry.Name, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Lstat(entry.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := entry.MatchStat(stat)
+	for _, bit := range []int{DATA_CHANGED, MODE_CHANGED} {
+		if changed&bit == 0 {
+			t.Errorf("MatchStat = %#x, missing %#x", changed, bit)
+		}
+	}
+}
+
+func TestSymlinkContents(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("target/path", link); err != nil {
+		t.Fatal(err)
+	}
+	file, stat, err := OpenContents(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "target/path" || stat.Size() != int64(len(contents)) {
+		t.Errorf("contents %q, size %d", contents, stat.Size())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := NewCacheEntryFromFilePath(link, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.STMode != syscall.S_IFLNK {
+		t.Errorf("STMode = %o, want a symlink", entry.STMode)
+	}
+}
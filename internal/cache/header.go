@@ -2,12 +2,33 @@ package cache
 
 import (
 	"bytes"
-	"crypto/sha1"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/hash"
 )
 
-const CACHE_SIGNATURE = "CRID" // 本当は"DIRC"だが、なぜか本家のindexを見ると"CRID"になっている...？
+// CACHE_SIGNATURE starts Git's index file. Its header and entries are big
+// endian and the file ends with a checksum of everything before it.
+const CACHE_SIGNATURE = "DIRC"
+
+// LEGACY_CACHE_SIGNATURE starts the little-endian version 1 index earlier
+// versions of this tool wrote, with the checksum in the header. It is still
+// read so that old repositories keep working; the next write converts it.
+const LEGACY_CACHE_SIGNATURE = "CRID"
+
+// Index format versions Git understands. Version 3 adds extended flags to
+// some entries and version 4 compresses paths against the previous entry.
+const (
+	INDEX_FORMAT_LB      = 2
+	INDEX_FORMAT_UB      = 4
+	INDEX_FORMAT_DEFAULT = 2
+	cacheHeaderSize      = 12
+)
 
 type CacheHeader struct {
 	Signature string
@@ -18,13 +39,33 @@ type CacheHeader struct {
 func NewCacheHeader(version uint32, entries []*CacheEntry) *CacheHeader {
 	return &CacheHeader{
 		Signature: CACHE_SIGNATURE,
-		Version:   1,
+		Version:   version,
 		Entries:   entries,
 	}
 }
 
+// DefaultVersion is the version a new index is written in: index.version
+// when it is set to one we can write, otherwise version 2. Only a config
+// that cannot be read is an error.
+func DefaultVersion() (uint32, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return 0, err
+	}
+	version, err := repositoryConfig.GetInt("index.version", INDEX_FORMAT_DEFAULT)
+	if err != nil {
+		log.Println("warning:", err)
+		return INDEX_FORMAT_DEFAULT, nil
+	}
+	if version < INDEX_FORMAT_LB || version > INDEX_FORMAT_UB {
+		log.Printf("warning: index.version set, but the value is invalid. Using version %d", INDEX_FORMAT_DEFAULT)
+		return INDEX_FORMAT_DEFAULT, nil
+	}
+	return uint32(version), nil
+}
+
 func (h *CacheHeader) Verify(expectSha1 []byte) error {
-	if h.Signature != CACHE_SIGNATURE {
+	if h.Signature != LEGACY_CACHE_SIGNATURE {
 		return errors.New("bad signature")
 	}
 	if h.Version != 1 {
@@ -36,30 +77,102 @@ func (h *CacheHeader) Verify(expectSha1 []byte) error {
 	return nil
 }
 
+// Sha1Hash is the checksum a legacy header carries.
 func (h *CacheHeader) Sha1Hash() []byte {
 	bytes := make([]byte, 0)
 	bytes = append(bytes, h.Signature...)
 	bytes = binary.LittleEndian.AppendUint32(bytes, h.Version)
 	bytes = binary.LittleEndian.AppendUint32(bytes, uint32(len(h.Entries)))
-	hash := sha1.New()
-	hash.Write(bytes)
+	checksum := hash.New()
+	checksum.Write(bytes)
 	for _, e := range h.Entries {
-		hash.Write(e.Bytes())
+		checksum.Write(e.legacyBytes())
 	}
-	return hash.Sum(nil)
+	return checksum.Sum(nil)
 }
 
 func NewCacheHeaderFromBytes(bytes []byte) (*CacheHeader, error) {
+	if len(bytes) < cacheHeaderSize {
+		return nil, errors.New("index file smaller than expected")
+	}
+	switch string(bytes[:4]) {
+	case CACHE_SIGNATURE:
+		return parseCacheHeader(bytes)
+	case LEGACY_CACHE_SIGNATURE:
+		return parseLegacyCacheHeader(bytes)
+	}
+	return nil, errors.New("bad signature")
+}
+
+func parseCacheHeader(data []byte) (*CacheHeader, error) {
+	header := &CacheHeader{Signature: CACHE_SIGNATURE}
+	header.Version = binary.BigEndian.Uint32(data[4:8])
+	if header.Version < INDEX_FORMAT_LB || header.Version > INDEX_FORMAT_UB {
+		return nil, fmt.Errorf("bad index version %d", header.Version)
+	}
+	hashSize := hash.Size()
+	if len(data) < cacheHeaderSize+hashSize {
+		return nil, errors.New("index file smaller than expected")
+	}
+	end := len(data) - hashSize
+	// index.skipHashで書かれたindexはチェックサムが0になっている
+	trailer := data[end:]
+	if !bytes.Equal(trailer, make([]byte, hashSize)) {
+		checksum := hash.New()
+		checksum.Write(data[:end])
+		if !bytes.Equal(checksum.Sum(nil), trailer) {
+			return nil, errors.New("bad index file sha1 signature")
+		}
+	}
+	entryCount := binary.BigEndian.Uint32(data[8:12])
+	header.Entries = make([]*CacheEntry, 0, min(int(entryCount), end/entrySize(hashSize, 0)))
+	offset := cacheHeaderSize
+	previousName := ""
+	for i := 0; i < int(entryCount); i++ {
+		entry, size, err := NewCacheEntryFromBytes(data[offset:end], header.Version, previousName)
+		if err != nil {
+			return nil, fmt.Errorf("index entry %d: %w", i, err)
+		}
+		header.Entries = append(header.Entries, entry)
+		previousName = entry.Name
+		offset += size
+	}
+	// 拡張は大文字で始まれば無視してよい
+	for offset < end {
+		if end-offset < 8 {
+			return nil, errors.New("index extension header truncated")
+		}
+		signature := string(data[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		if size > end-offset-8 {
+			return nil, fmt.Errorf("index extension %q truncated", signature)
+		}
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, fmt.Errorf("index uses %q extension, which we do not understand", signature)
+		}
+		offset += 8 + size
+	}
+	return header, nil
+}
+
+func parseLegacyCacheHeader(data []byte) (*CacheHeader, error) {
 	header := &CacheHeader{}
-	header.Signature = string(bytes[:4])
-	header.Version = binary.LittleEndian.Uint32(bytes[4:8])
-	entryCount := binary.LittleEndian.Uint32(bytes[8:12])
-	header.Entries = make([]*CacheEntry, entryCount)
-	sha1HashFromByte := bytes[12:32]
-	offset := uint32(32)
+	header.Signature = string(data[:4])
+	header.Version = binary.LittleEndian.Uint32(data[4:8])
+	entryCount := binary.LittleEndian.Uint32(data[8:12])
+	hashSize := hash.Size()
+	if len(data) < cacheHeaderSize+hashSize {
+		return nil, errors.New("index file smaller than expected")
+	}
+	sha1HashFromByte := data[12 : 12+hashSize]
+	offset := 12 + hashSize
+	header.Entries = make([]*CacheEntry, 0, min(int(entryCount), len(data)/entrySize(hashSize, 0)))
 	for i := 0; i < int(entryCount); i++ {
-		entry, size := NewCacheEntryFromBytes(bytes[offset:])
-		header.Entries[i] = entry
+		entry, size, err := newCacheEntryFromLegacyBytes(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("index entry %d: %w", i, err)
+		}
+		header.Entries = append(header.Entries, entry)
 		offset += size
 	}
 	if err := header.Verify(sha1HashFromByte); err != nil {
@@ -69,10 +182,34 @@ func NewCacheHeaderFromBytes(bytes []byte) (*CacheHeader, error) {
 }
 
 func (h *CacheHeader) Bytes() []byte {
-	bytes := make([]byte, 0)
+	bytes := make([]byte, 0, cacheHeaderSize)
 	bytes = append(bytes, h.Signature...)
-	bytes = binary.LittleEndian.AppendUint32(bytes, h.Version)
-	bytes = binary.LittleEndian.AppendUint32(bytes, uint32(len(h.Entries)))
-	bytes = append(bytes, h.Sha1Hash()...)
+	bytes = binary.BigEndian.AppendUint32(bytes, h.Version)
+	bytes = binary.BigEndian.AppendUint32(bytes, uint32(len(h.Entries)))
 	return bytes
 }
+
+// WriteTo writes the header, the entries and the trailing checksum. No
+// extensions are written: the ones Git may drop are the only ones read.
+func (h *CacheHeader) WriteTo(w io.Writer) (int64, error) {
+	checksum := hash.New()
+	writer := io.MultiWriter(w, checksum)
+	written := int64(0)
+	n, err := writer.Write(h.Bytes())
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	previousName := ""
+	for _, entry := range h.Entries {
+		n, err := writer.Write(entry.encode(h.Version, previousName))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		previousName = entry.Name
+	}
+	n, err = w.Write(checksum.Sum(nil))
+	written += int64(n)
+	return written, err
+}
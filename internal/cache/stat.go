@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"github.com/marutaku/go-git/internal/cache/cachetime"
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// Bits MatchStat returns for what differs between an entry and its file.
+const (
+	MTIME_CHANGED = 0x0001
+	CTIME_CHANGED = 0x0002
+	OWNER_CHANGED = 0x0004
+	MODE_CHANGED  = 0x0008
+	INODE_CHANGED = 0x0010
+	DATA_CHANGED  = 0x0020
+	TYPE_CHANGED  = 0x0040
+)
+
+// indexTimestamp is the mtime of the index ReadCache read, zero when there
+// was none.
+var indexTimestamp cachetime.CacheTime
+
+// MatchStat compares the stat data kept in the entry with stat of its file.
+func (e *CacheEntry) MatchStat(stat fs.FileInfo) int {
+	changed := 0
+	sys := stat.Sys().(*syscall.Stat_t)
+	ctime := cachetime.NewCTimeFromStat(stat)
+	mtime := cachetime.NewMTimeFromStat(stat)
+	if ctime.Sec != e.CTime.Sec || ctime.NSec != e.CTime.NSec {
+		changed |= CTIME_CHANGED
+	}
+	if mtime.Sec != e.MTime.Sec || mtime.NSec != e.MTime.NSec {
+		changed |= MTIME_CHANGED
+	}
+	if sys.Uid != e.STUid || sys.Gid != e.STGid {
+		changed |= OWNER_CHANGED
+	}
+	mode := CreateCacheMode(uint32(sys.Mode))
+	if mode&syscall.S_IFMT != e.STMode&syscall.S_IFMT {
+		changed |= TYPE_CHANGED
+	} else if mode != e.STMode {
+		changed |= MODE_CHANGED
+	}
+	if uint32(sys.Ino) != e.STIno {
+		changed |= INODE_CHANGED
+	}
+	if uint32(stat.Size()) != e.STSize {
+		changed |= DATA_CHANGED
+	}
+	return changed
+}
+
+// IsRacy reports whether the file may have changed in the same timestamp
+// tick as the index was written, after the entry's stat data was taken.
+// Such an entry can match its file's stat data while the contents differ.
+func (e *CacheEntry) IsRacy() bool {
+	if indexTimestamp.Sec == 0 {
+		return false
+	}
+	return indexTimestamp.Sec < e.MTime.Sec ||
+		(indexTimestamp.Sec == e.MTime.Sec && indexTimestamp.NSec <= e.MTime.NSec)
+}
+
+// contentsMatch hashes the entry's file and compares it with the entry.
+func (e *CacheEntry) contentsMatch(stat fs.FileInfo) (bool, error) {
+	file, _, err := OpenContents(e.Name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	sha1, err := hash.CalculateSha1HashFromFileStat(stat, file)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sha1, e.Sha1), nil
+}
+
+// Changed is MatchStat, except that an entry whose stat data cannot be
+// trusted, because it is racy or was smudged when the index was written, is
+// compared with the file by contents.
+func (e *CacheEntry) Changed(stat fs.FileInfo) (int, error) {
+	changed := e.MatchStat(stat)
+	if changed != 0 || !(e.IsRacy() || e.STSize == 0) {
+		return changed, nil
+	}
+	same, err := e.contentsMatch(stat)
+	if err != nil {
+		return 0, err
+	}
+	if !same {
+		changed |= DATA_CHANGED
+	}
+	return changed, nil
+}
+
+// smudgeRacilyClean clears the size of a racy entry whose file no longer
+// matches it. Once the index is rewritten the entry would stop being racy,
+// so this is the only way later readers learn its stat data is wrong.
+func (e *CacheEntry) smudgeRacilyClean() {
+	if !e.IsRacy() || e.STSize == 0 {
+		return
+	}
+	stat, err := os.Lstat(e.Name)
+	if err != nil {
+		return
+	}
+	if e.MatchStat(stat) != 0 {
+		return
+	}
+	if same, err := e.contentsMatch(stat); err != nil || !same {
+		e.STSize = 0
+	}
+}
@@ -0,0 +1,203 @@
+package commitgraph
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strings"
+)
+
+// Chunks holding the changed-path Bloom filters: BIDX has the end offset of
+// every commit's filter inside BDAT, BDAT a small header and the filters.
+const (
+	GRAPH_CHUNK_BLOOM_INDEXES = 0x42494458 // "BIDX"
+	GRAPH_CHUNK_BLOOM_DATA    = 0x42444154 // "BDAT"
+)
+
+// Settings of the filters, as Git writes them. Version 1 hashes bytes as
+// signed chars; it is what every Git release reads.
+const (
+	BLOOM_HASH_VERSION       = 1
+	BLOOM_NUM_HASHES         = 7
+	BLOOM_BITS_PER_ENTRY     = 10
+	BLOOM_MAX_CHANGED_PATHS  = 512
+	BLOOM_DATA_HEADER_SIZE   = 12
+	bloomSeed0               = 0x293ae76f
+	bloomSeed1               = 0x7e646e2c
+	bloomBitsPerWord         = 8
+	bloomTooLargeFilterValue = 0xff
+)
+
+// BloomFilter records which paths a commit changed compared to its first
+// parent. It can only say for certain that a path did not change.
+type BloomFilter struct {
+	Data      []byte
+	hashes    int
+	v1Hashing bool
+}
+
+// murmur3 is MurmurHash3 (x86, 32 bit). signedBytes reproduces the version 1
+// filters, which hashed bytes as C chars.
+func murmur3(seed uint32, data []byte, signedBytes bool) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+		n  = 0xe6546b64
+	)
+	byteAt := func(i int) uint32 {
+		if signedBytes {
+			return uint32(int32(int8(data[i])))
+		}
+		return uint32(data[i])
+	}
+	len4 := len(data) / 4
+	for i := 0; i < len4; i++ {
+		k := byteAt(4*i) | byteAt(4*i+1)<<8 | byteAt(4*i+2)<<16 | byteAt(4*i+3)<<24
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		seed ^= k
+		seed = bits.RotateLeft32(seed, 13)*5 + n
+	}
+	tail := len4 * 4
+	var k1 uint32
+	switch len(data) & 3 {
+	case 3:
+		k1 ^= byteAt(tail+2) << 16
+		fallthrough
+	case 2:
+		k1 ^= byteAt(tail+1) << 8
+		fallthrough
+	case 1:
+		k1 ^= byteAt(tail)
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+		seed ^= k1
+	}
+	seed ^= uint32(len(data))
+	seed ^= seed >> 16
+	seed *= 0x85ebca6b
+	seed ^= seed >> 13
+	seed *= 0xc2b2ae35
+	seed ^= seed >> 16
+	return seed
+}
+
+func (f *BloomFilter) positions(path string) []uint64 {
+	hash0 := murmur3(bloomSeed0, []byte(path), f.v1Hashing)
+	hash1 := murmur3(bloomSeed1, []byte(path), f.v1Hashing)
+	modulo := uint64(len(f.Data)) * bloomBitsPerWord
+	positions := make([]uint64, f.hashes)
+	for i := range positions {
+		positions[i] = uint64(hash0+uint32(i)*hash1) % modulo
+	}
+	return positions
+}
+
+// NewBloomFilter builds the filter for a commit that changed paths. Every
+// leading directory of a path is added too, so that directories can be
+// looked up. Commits changing too many paths get a filter that matches
+// everything.
+func NewBloomFilter(paths []string) *BloomFilter {
+	filter := &BloomFilter{hashes: BLOOM_NUM_HASHES, v1Hashing: true}
+	keys := map[string]bool{}
+	for _, path := range paths {
+		for path != "" && !keys[path] {
+			keys[path] = true
+			slash := strings.LastIndexByte(path, '/')
+			if slash < 0 {
+				break
+			}
+			path = path[:slash]
+		}
+	}
+	if len(paths) > BLOOM_MAX_CHANGED_PATHS || len(keys) > BLOOM_MAX_CHANGED_PATHS {
+		filter.Data = []byte{bloomTooLargeFilterValue}
+		return filter
+	}
+	size := (len(keys)*BLOOM_BITS_PER_ENTRY + bloomBitsPerWord - 1) / bloomBitsPerWord
+	if size == 0 {
+		size = 1
+	}
+	filter.Data = make([]byte, size)
+	for key := range keys {
+		for _, position := range filter.positions(key) {
+			filter.Data[position/bloomBitsPerWord] |= 1 << (position % bloomBitsPerWord)
+		}
+	}
+	return filter
+}
+
+// Reusable reports whether the filter was made with the settings this
+// package writes, so that it can be copied into a new graph as it is.
+func (f *BloomFilter) Reusable() bool {
+	return f.v1Hashing && f.hashes == BLOOM_NUM_HASHES
+}
+
+// MaybeContains reports false only when path certainly did not change.
+func (f *BloomFilter) MaybeContains(path string) bool {
+	if len(f.Data) == 0 {
+		return true
+	}
+	for _, position := range f.positions(strings.TrimSuffix(path, "/")) {
+		if f.Data[position/bloomBitsPerWord]&(1<<(position%bloomBitsPerWord)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomFilter returns the changed-path filter of the commit at pos, or nil
+// when the graph has none for it.
+func (graph *Graph) BloomFilter(pos int) *BloomFilter {
+	if graph.bloomIndexes == nil {
+		return nil
+	}
+	start := uint32(0)
+	if pos > 0 {
+		start = binary.BigEndian.Uint32(graph.bloomIndexes[(pos-1)*4:])
+	}
+	end := binary.BigEndian.Uint32(graph.bloomIndexes[pos*4:])
+	if start >= end || int(end) > len(graph.bloomData) {
+		// 長さ0は計算されていないという意味
+		return nil
+	}
+	return &BloomFilter{Data: graph.bloomData[start:end], hashes: graph.bloomHashes, v1Hashing: graph.bloomVersion == 1}
+}
+
+// HasBloomFilters reports whether the graph carries changed-path filters.
+func (graph *Graph) HasBloomFilters() bool {
+	return graph.bloomIndexes != nil
+}
+
+// parseBloomChunks loads BIDX and BDAT when both are present and written
+// with settings this package understands; otherwise filters are not used.
+func (graph *Graph) parseBloomChunks(indexes []byte, data []byte) {
+	if indexes == nil || len(data) < BLOOM_DATA_HEADER_SIZE || len(indexes) != graph.Count()*4 {
+		return
+	}
+	version := binary.BigEndian.Uint32(data[0:4])
+	if version != 1 && version != 2 {
+		return
+	}
+	graph.bloomVersion = version
+	graph.bloomHashes = int(binary.BigEndian.Uint32(data[4:8]))
+	graph.bloomIndexes = indexes
+	graph.bloomData = data[BLOOM_DATA_HEADER_SIZE:]
+}
+
+// bloomChunks builds BIDX and BDAT for entries in graph order. An entry
+// without a filter gets an empty one, which Git reads as "not computed".
+func bloomChunks(sorted []*Entry) ([]byte, []byte) {
+	var indexes []byte
+	data := binary.BigEndian.AppendUint32(nil, BLOOM_HASH_VERSION)
+	data = binary.BigEndian.AppendUint32(data, BLOOM_NUM_HASHES)
+	data = binary.BigEndian.AppendUint32(data, BLOOM_BITS_PER_ENTRY)
+	for _, entry := range sorted {
+		if entry.Bloom != nil {
+			data = append(data, entry.Bloom.Data...)
+		}
+		indexes = binary.BigEndian.AppendUint32(indexes, uint32(len(data)-BLOOM_DATA_HEADER_SIZE))
+	}
+	return indexes, data
+}
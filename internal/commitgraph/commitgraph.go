@@ -0,0 +1,455 @@
+// Package commitgraph reads and writes Git's commit-graph file. It keeps the
+// parents, root tree, commit date and generation number of every commit it
+// covers, so history can be walked without inflating commit objects.
+package commitgraph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+var GRAPH_SIGNATURE = []byte("CGPH")
+
+const GRAPH_VERSION = 1
+
+// Chunk IDs of the commit-graph.
+const (
+	GRAPH_CHUNK_OID_FANOUT  = 0x4f494446 // "OIDF"
+	GRAPH_CHUNK_OID_LOOKUP  = 0x4f49444c // "OIDL"
+	GRAPH_CHUNK_DATA        = 0x43444154 // "CDAT"
+	GRAPH_CHUNK_EXTRA_EDGES = 0x45444745 // "EDGE"
+)
+
+const GRAPH_HEADER_SIZE = 8
+const GRAPH_CHUNK_LOOKUP_WIDTH = 12
+
+// Parent fields of CDAT. An octopus merge keeps its second parent onwards in
+// EDGE; the last of them is marked with GRAPH_LAST_EDGE.
+const (
+	GRAPH_PARENT_NONE  = 0x70000000
+	GRAPH_EXTRA_EDGES  = 0x80000000
+	GRAPH_LAST_EDGE    = 0x80000000
+	GRAPH_EDGE_MASK    = 0x7fffffff
+	GENERATION_MAX     = 0x3fffffff
+	COMMIT_TIME_MAX    = 1<<34 - 1
+	GENERATION_UNKNOWN = 0xffffffff
+)
+
+// StoredTime is the commit date a graph records for a commit dated t. Dates
+// that do not fit in 34 bits, including any before 1970, are stored as 0.
+func StoredTime(t int64) int64 {
+	if t < 0 || t > COMMIT_TIME_MAX {
+		return 0
+	}
+	return t
+}
+
+// FileName returns where the commit-graph of the repository lives.
+func FileName() string {
+	return filepath.Join(env.GetSHA1FileDirectory(), "objects", "info", "commit-graph")
+}
+
+// Graph is a parsed commit-graph. Commits are numbered by their position in
+// the sorted list of IDs.
+type Graph struct {
+	fanout   [256]uint32
+	names    []byte
+	data     []byte
+	edges    []byte
+	hashSize int
+	Checksum []byte
+
+	bloomIndexes []byte
+	bloomData    []byte
+	bloomVersion uint32
+	bloomHashes  int
+}
+
+// Commit is what the graph records about one commit. Parents are positions
+// in the graph.
+type Commit struct {
+	Tree       []byte
+	Parents    []int
+	Time       int64
+	Generation uint32
+}
+
+var current *Graph
+var currentLoaded bool
+
+// Current returns the commit-graph of the repository, loading it on first
+// use, or nil when there is none. A graph that cannot be read is ignored:
+// callers fall back to the objects themselves.
+func Current() *Graph {
+	if !currentLoaded {
+		currentLoaded = true
+		graph, err := Read(FileName())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("warning: ignoring commit-graph: %v", err)
+		}
+		current = graph
+	}
+	return current
+}
+
+func Read(fileName string) (*Graph, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return graph, nil
+}
+
+// readChunks returns the chunks of a chunked file whose lookup table starts
+// at offset, keyed by ID. Chunks must lie before the trailing checksum.
+func readChunks(data []byte, offset int, chunkCount int, hashSize int) (map[uint32][]byte, error) {
+	if len(data) < offset+(chunkCount+1)*GRAPH_CHUNK_LOOKUP_WIDTH+hashSize {
+		return nil, errors.New("chunk table truncated")
+	}
+	end := uint64(len(data) - hashSize)
+	chunks := map[uint32][]byte{}
+	for i := 0; i < chunkCount; i++ {
+		entry := data[offset+i*GRAPH_CHUNK_LOOKUP_WIDTH:]
+		next := data[offset+(i+1)*GRAPH_CHUNK_LOOKUP_WIDTH:]
+		start := binary.BigEndian.Uint64(entry[4:12])
+		stop := binary.BigEndian.Uint64(next[4:12])
+		if start > stop || stop > end {
+			return nil, errors.New("chunk out of bounds")
+		}
+		chunks[binary.BigEndian.Uint32(entry[0:4])] = data[start:stop]
+	}
+	return chunks, nil
+}
+
+func Parse(data []byte) (*Graph, error) {
+	hashSize := hash.Size()
+	if len(data) < GRAPH_HEADER_SIZE+GRAPH_CHUNK_LOOKUP_WIDTH+hashSize {
+		return nil, errors.New("commit-graph too small")
+	}
+	if !bytes.Equal(data[:4], GRAPH_SIGNATURE) {
+		return nil, errors.New("bad commit-graph signature")
+	}
+	if data[4] != GRAPH_VERSION {
+		return nil, fmt.Errorf("unsupported commit-graph version %d", data[4])
+	}
+	algorithm, err := hash.Current()
+	if err != nil {
+		return nil, err
+	}
+	if data[5] != algorithm.FormatID {
+		return nil, errors.New("commit-graph hash version does not match the repository")
+	}
+	if data[7] != 0 {
+		return nil, errors.New("split commit-graphs are not supported")
+	}
+	chunks, err := readChunks(data, GRAPH_HEADER_SIZE, int(data[6]), hashSize)
+	if err != nil {
+		return nil, err
+	}
+	graph := &Graph{hashSize: hashSize, Checksum: data[len(data)-hashSize:]}
+	fanout := chunks[GRAPH_CHUNK_OID_FANOUT]
+	if len(fanout) != 256*4 {
+		return nil, errors.New("commit-graph fanout is missing")
+	}
+	for i := range graph.fanout {
+		graph.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && graph.fanout[i] < graph.fanout[i-1] {
+			return nil, errors.New("commit-graph fanout is not monotonic")
+		}
+	}
+	count := int(graph.fanout[255])
+	graph.names = chunks[GRAPH_CHUNK_OID_LOOKUP]
+	graph.data = chunks[GRAPH_CHUNK_DATA]
+	graph.edges = chunks[GRAPH_CHUNK_EXTRA_EDGES]
+	if len(graph.names) != count*hashSize || len(graph.data) != count*(hashSize+16) {
+		return nil, errors.New("commit-graph commit chunks are truncated")
+	}
+	graph.parseBloomChunks(chunks[GRAPH_CHUNK_BLOOM_INDEXES], chunks[GRAPH_CHUNK_BLOOM_DATA])
+	return graph, nil
+}
+
+// Verify checks the trailing checksum of the file graph was read from.
+func (graph *Graph) Verify(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data[:len(data)-graph.hashSize])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-graph.hashSize:]) {
+		return errors.New("commit-graph checksum mismatch")
+	}
+	return nil
+}
+
+func (graph *Graph) Count() int {
+	return int(graph.fanout[255])
+}
+
+func (graph *Graph) Sha1(pos int) []byte {
+	return graph.names[pos*graph.hashSize : (pos+1)*graph.hashSize]
+}
+
+// Find returns the position of sha1 in the graph.
+func (graph *Graph) Find(sha1 []byte) (int, bool) {
+	low := 0
+	if sha1[0] > 0 {
+		low = int(graph.fanout[sha1[0]-1])
+	}
+	high := int(graph.fanout[sha1[0]])
+	pos := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(graph.Sha1(low+i), sha1) >= 0
+	})
+	if pos < high && bytes.Equal(graph.Sha1(pos), sha1) {
+		return pos, true
+	}
+	return 0, false
+}
+
+func (graph *Graph) parent(value uint32, parents []int) ([]int, error) {
+	if value == GRAPH_PARENT_NONE {
+		return parents, nil
+	}
+	if int(value) >= graph.Count() {
+		return nil, fmt.Errorf("parent position %d out of range", value)
+	}
+	return append(parents, int(value)), nil
+}
+
+// Commit returns what the graph records for the commit at pos.
+func (graph *Graph) Commit(pos int) (*Commit, error) {
+	record := graph.data[pos*(graph.hashSize+16) : (pos+1)*(graph.hashSize+16)]
+	fields := record[graph.hashSize:]
+	commit := &Commit{Tree: record[:graph.hashSize]}
+	var err error
+	if commit.Parents, err = graph.parent(binary.BigEndian.Uint32(fields[0:4]), nil); err != nil {
+		return nil, err
+	}
+	second := binary.BigEndian.Uint32(fields[4:8])
+	if second&GRAPH_EXTRA_EDGES == 0 {
+		if commit.Parents, err = graph.parent(second, commit.Parents); err != nil {
+			return nil, err
+		}
+	} else {
+		// 3つ以上の親はEDGEチャンクに続けて並んでいる
+		for edge := int(second & GRAPH_EDGE_MASK); ; edge++ {
+			if (edge+1)*4 > len(graph.edges) {
+				return nil, errors.New("extra edges out of range")
+			}
+			value := binary.BigEndian.Uint32(graph.edges[edge*4:])
+			if commit.Parents, err = graph.parent(value&GRAPH_EDGE_MASK, commit.Parents); err != nil {
+				return nil, err
+			}
+			if value&GRAPH_LAST_EDGE != 0 {
+				break
+			}
+		}
+	}
+	high := binary.BigEndian.Uint32(fields[8:12])
+	commit.Generation = high >> 2
+	commit.Time = int64(high&3)<<32 | int64(binary.BigEndian.Uint32(fields[12:16]))
+	return commit, nil
+}
+
+// Entry is a commit to be written into a graph. When any entry has a Bloom
+// filter, the graph gets changed-path filters.
+type Entry struct {
+	Sha1    []byte
+	Tree    []byte
+	Parents [][]byte
+	Time    int64
+	Bloom   *BloomFilter
+}
+
+// Generations computes the generation number of every entry: one for a
+// root, otherwise one more than the highest of its parents. Every parent
+// must be among the entries.
+func Generations(entries []*Entry) (map[string]uint32, error) {
+	bySha1 := make(map[string]*Entry, len(entries))
+	for _, entry := range entries {
+		bySha1[string(entry.Sha1)] = entry
+	}
+	generations := make(map[string]uint32, len(entries))
+	for _, entry := range entries {
+		// 長い履歴で再帰が深くならないようにスタックで辿る
+		stack := []*Entry{entry}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if _, ok := generations[string(top.Sha1)]; ok {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			generation := uint32(1)
+			pending := false
+			for _, parent := range top.Parents {
+				parentEntry, ok := bySha1[string(parent)]
+				if !ok {
+					return nil, fmt.Errorf("commit %x: parent %x is not in the graph", top.Sha1, parent)
+				}
+				parentGeneration, ok := generations[string(parent)]
+				if !ok {
+					stack = append(stack, parentEntry)
+					pending = true
+					continue
+				}
+				if parentGeneration+1 > generation {
+					generation = parentGeneration + 1
+				}
+			}
+			if pending {
+				continue
+			}
+			if generation > GENERATION_MAX {
+				generation = GENERATION_MAX
+			}
+			generations[string(top.Sha1)] = generation
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return generations, nil
+}
+
+// Write writes a commit-graph covering entries.
+func Write(w io.Writer, entries []*Entry) error {
+	sorted := append([]*Entry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Sha1, sorted[j].Sha1) < 0
+	})
+	generations, err := Generations(sorted)
+	if err != nil {
+		return err
+	}
+	positions := make(map[string]uint32, len(sorted))
+	for i, entry := range sorted {
+		positions[string(entry.Sha1)] = uint32(i)
+	}
+
+	var fanout, names, data, edges []byte
+	var counts [256]uint32
+	for _, entry := range sorted {
+		counts[entry.Sha1[0]]++
+		names = append(names, entry.Sha1...)
+		data = append(data, entry.Tree...)
+		parents := [2]uint32{GRAPH_PARENT_NONE, GRAPH_PARENT_NONE}
+		for i, parent := range entry.Parents {
+			if i < 2 {
+				parents[i] = positions[string(parent)]
+			}
+		}
+		if len(entry.Parents) > 2 {
+			parents[1] = GRAPH_EXTRA_EDGES | uint32(len(edges)/4)
+			for i, parent := range entry.Parents[1:] {
+				value := positions[string(parent)]
+				if i == len(entry.Parents)-2 {
+					value |= GRAPH_LAST_EDGE
+				}
+				edges = binary.BigEndian.AppendUint32(edges, value)
+			}
+		}
+		data = binary.BigEndian.AppendUint32(data, parents[0])
+		data = binary.BigEndian.AppendUint32(data, parents[1])
+		commitTime := StoredTime(entry.Time)
+		data = binary.BigEndian.AppendUint32(data, generations[string(entry.Sha1)]<<2|uint32(commitTime>>32))
+		data = binary.BigEndian.AppendUint32(data, uint32(commitTime))
+	}
+	total := uint32(0)
+	for _, count := range counts {
+		total += count
+		fanout = binary.BigEndian.AppendUint32(fanout, total)
+	}
+	chunks := []chunk{
+		{GRAPH_CHUNK_OID_FANOUT, fanout},
+		{GRAPH_CHUNK_OID_LOOKUP, names},
+		{GRAPH_CHUNK_DATA, data},
+	}
+	if len(edges) > 0 {
+		chunks = append(chunks, chunk{GRAPH_CHUNK_EXTRA_EDGES, edges})
+	}
+	for _, entry := range sorted {
+		if entry.Bloom != nil {
+			indexes, data := bloomChunks(sorted)
+			chunks = append(chunks, chunk{GRAPH_CHUNK_BLOOM_INDEXES, indexes}, chunk{GRAPH_CHUNK_BLOOM_DATA, data})
+			break
+		}
+	}
+	return writeChunks(w, chunks)
+}
+
+type chunk struct {
+	id   uint32
+	data []byte
+}
+
+func writeChunks(w io.Writer, chunks []chunk) error {
+	algorithm, err := hash.Current()
+	if err != nil {
+		return err
+	}
+	h := algorithm.New()
+	out := io.MultiWriter(w, h)
+	buffer := append([]byte(nil), GRAPH_SIGNATURE...)
+	buffer = append(buffer, GRAPH_VERSION, algorithm.FormatID, byte(len(chunks)), 0)
+	offset := uint64(GRAPH_HEADER_SIZE + (len(chunks)+1)*GRAPH_CHUNK_LOOKUP_WIDTH)
+	for _, c := range chunks {
+		buffer = binary.BigEndian.AppendUint32(buffer, c.id)
+		buffer = binary.BigEndian.AppendUint64(buffer, offset)
+		offset += uint64(len(c.data))
+	}
+	buffer = binary.BigEndian.AppendUint32(buffer, 0)
+	buffer = binary.BigEndian.AppendUint64(buffer, offset)
+	for _, c := range chunks {
+		buffer = append(buffer, c.data...)
+	}
+	if _, err := out.Write(buffer); err != nil {
+		return err
+	}
+	_, err = w.Write(h.Sum(nil))
+	return err
+}
+
+// WriteFile replaces the commit-graph of the repository with one covering
+// entries.
+func WriteFile(entries []*Entry) error {
+	fileName := FileName()
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(fileName), "tmp_graph_")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = Write(writer, entries)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	// 読み込み専用にしておくのはGitと同じ
+	if err := file.Chmod(0444); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	return durable.CommitFile(file, fileName, config.FSYNC_COMMIT_GRAPH)
+}
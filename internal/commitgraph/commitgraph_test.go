@@ -0,0 +1,231 @@
+package commitgraph
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testID(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 20)
+}
+
+func TestWriteParseRoundTrip(t *testing.T) {
+	root := &Entry{Sha1: testID(0x50), Tree: testID(0xa0), Time: 1700000000}
+	second := &Entry{Sha1: testID(0x10), Tree: testID(0xa1), Parents: [][]byte{root.Sha1}, Time: COMMIT_TIME_MAX}
+	side := &Entry{Sha1: testID(0xf0), Tree: testID(0xa2), Parents: [][]byte{root.Sha1}, Time: COMMIT_TIME_MAX + 1}
+	other := &Entry{Sha1: testID(0x30), Tree: testID(0xa3), Time: -1}
+	octopus := &Entry{Sha1: testID(0x20), Tree: testID(0xa4), Parents: [][]byte{second.Sha1, side.Sha1, other.Sha1}, Time: 1 << 33}
+	entries := []*Entry{octopus, second, root, side, other}
+	tests := []struct {
+		entry      *Entry
+		generation uint32
+	}{
+		{root, 1},
+		{second, 2},
+		{side, 2},
+		{other, 1},
+		{octopus, 3},
+	}
+
+	var buffer bytes.Buffer
+	if err := Write(&buffer, entries); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := Parse(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if graph.Count() != len(entries) {
+		t.Fatalf("Count = %d, want %d", graph.Count(), len(entries))
+	}
+	if graph.HasBloomFilters() {
+		t.Errorf("graph written without filters has Bloom chunks")
+	}
+	for _, test := range tests {
+		pos, ok := graph.Find(test.entry.Sha1)
+		if !ok {
+			t.Fatalf("%x not found", test.entry.Sha1)
+		}
+		commit, err := graph.Commit(pos)
+		if err != nil {
+			t.Fatalf("%x: %v", test.entry.Sha1, err)
+		}
+		if !bytes.Equal(commit.Tree, test.entry.Tree) {
+			t.Errorf("%x: tree %x, want %x", test.entry.Sha1, commit.Tree, test.entry.Tree)
+		}
+		var parents [][]byte
+		for _, parent := range commit.Parents {
+			parents = append(parents, graph.Sha1(parent))
+		}
+		if !slices.EqualFunc(parents, test.entry.Parents, bytes.Equal) {
+			t.Errorf("%x: parents %x, want %x", test.entry.Sha1, parents, test.entry.Parents)
+		}
+		if want := StoredTime(test.entry.Time); commit.Time != want {
+			t.Errorf("%x: time %d, want %d", test.entry.Sha1, commit.Time, want)
+		}
+		if commit.Generation != test.generation {
+			t.Errorf("%x: generation %d, want %d", test.entry.Sha1, commit.Generation, test.generation)
+		}
+	}
+	if _, ok := graph.Find(testID(0x99)); ok {
+		t.Errorf("Find reports a commit that was never written")
+	}
+
+	fileName := filepath.Join(t.TempDir(), "commit-graph")
+	if err := os.WriteFile(fileName, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Verify(fileName); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	corrupt := append([]byte(nil), buffer.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := os.WriteFile(fileName, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.Verify(fileName); err == nil {
+		t.Errorf("Verify accepted a bad checksum")
+	}
+}
+
+func TestStoredTime(t *testing.T) {
+	tests := []struct {
+		time, want int64
+	}{
+		{0, 0},
+		{1700000000, 1700000000},
+		{COMMIT_TIME_MAX, COMMIT_TIME_MAX},
+		{COMMIT_TIME_MAX + 1, 0},
+		{-1, 0},
+	}
+	for _, test := range tests {
+		if got := StoredTime(test.time); got != test.want {
+			t.Errorf("StoredTime(%d) = %d, want %d", test.time, got, test.want)
+		}
+	}
+}
+
+func TestWriteRejectsMissingParents(t *testing.T) {
+	entries := []*Entry{{Sha1: testID(1), Tree: testID(2), Parents: [][]byte{testID(3)}}}
+	if err := Write(&bytes.Buffer{}, entries); err == nil {
+		t.Errorf("Write accepted a commit whose parent is not in the graph")
+	}
+}
+
+func TestParseRejectsMalformedGraphs(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := Write(&buffer, []*Entry{{Sha1: testID(1), Tree: testID(2)}}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buffer.Bytes()
+	modified := func(offset int, value byte) []byte {
+		data := append([]byte(nil), valid...)
+		data[offset] = value
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too small", valid[:8]},
+		{"bad signature", modified(0, 'X')},
+		{"unknown version", modified(4, 2)},
+		{"other hash", modified(5, 2)},
+		{"split graph", modified(7, 1)},
+		{"chunk out of bounds", valid[:len(valid)-30]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.data); err == nil {
+				t.Errorf("Parse accepted a malformed commit-graph")
+			}
+		})
+	}
+}
+
+func TestMurmur3(t *testing.T) {
+	// Git's t0095-bloom.shと同じ値
+	tests := []struct {
+		data string
+		want uint32
+	}{
+		{"", 0x00000000},
+		{"Hello world!", 0x627b0c2c},
+		{"The quick brown fox jumps over the lazy dog", 0x2e4ff723},
+	}
+	for _, test := range tests {
+		if got := murmur3(0, []byte(test.data), true); got != test.want {
+			t.Errorf("murmur3(%q) = %#08x, want %#08x", test.data, got, test.want)
+		}
+	}
+	// 0x80以上のバイトはバージョン1だけ符号付きで扱う
+	high := []byte{0x99, 0xaa, 0xbb, 0xcc, 0xdd}
+	if murmur3(0, high, true) == murmur3(0, high, false) {
+		t.Errorf("signed and unsigned hashing agree on bytes above 0x7f")
+	}
+}
+
+func TestBloomFilters(t *testing.T) {
+	root := &Entry{Sha1: testID(1), Tree: testID(2), Bloom: NewBloomFilter([]string{"README", "src/main.go"})}
+	var many []string
+	for i := 0; i <= BLOOM_MAX_CHANGED_PATHS; i++ {
+		many = append(many, "dir/file"+strings.Repeat("x", i))
+	}
+	large := &Entry{Sha1: testID(3), Tree: testID(4), Parents: [][]byte{root.Sha1}, Bloom: NewBloomFilter(many)}
+	none := &Entry{Sha1: testID(5), Tree: testID(6), Parents: [][]byte{large.Sha1}}
+	var buffer bytes.Buffer
+	if err := Write(&buffer, []*Entry{root, large, none}); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := Parse(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !graph.HasBloomFilters() {
+		t.Fatal("graph has no Bloom chunks")
+	}
+	filter := func(sha1 []byte) *BloomFilter {
+		pos, ok := graph.Find(sha1)
+		if !ok {
+			t.Fatalf("%x not found", sha1)
+		}
+		return graph.BloomFilter(pos)
+	}
+	rootFilter := filter(root.Sha1)
+	if rootFilter == nil || !rootFilter.Reusable() {
+		t.Fatalf("root filter = %v, want a reusable filter", rootFilter)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"README", true},
+		{"src/main.go", true},
+		{"src", true},
+		{"src/", true},
+	}
+	for _, test := range tests {
+		if got := rootFilter.MaybeContains(test.path); got != test.want {
+			t.Errorf("MaybeContains(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+	rejected := 0
+	for i := 0; i < 100; i++ {
+		if !rootFilter.MaybeContains("unchanged/" + strings.Repeat("y", i)) {
+			rejected++
+		}
+	}
+	if rejected < 90 {
+		t.Errorf("filter rejected only %d of 100 unchanged paths", rejected)
+	}
+	if largeFilter := filter(large.Sha1); largeFilter == nil || !largeFilter.MaybeContains("anything") {
+		t.Errorf("a commit changing too many paths must match every path")
+	}
+	if filter(none.Sha1) != nil {
+		t.Errorf("a commit written without a filter has one")
+	}
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/env"
+)
+
+// Config holds the repository settings stored in <SHA1_FILE_DIRECTORY>/config.
+// The file uses Git's ini-like syntax; keys are addressed as "section.name".
+type Config struct {
+	sections []*section
+}
+
+type section struct {
+	name   string
+	values []keyValue
+}
+
+type keyValue struct {
+	key   string
+	value string
+}
+
+func GetConfigFileName() string {
+	return fmt.Sprintf("%s/config", env.GetSHA1FileDirectory())
+}
+
+// Load reads the repository config. A missing file is an empty config.
+func Load() (*Config, error) {
+	contents, err := os.ReadFile(GetConfigFileName())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, err
+	}
+	return Parse(contents)
+}
+
+func Parse(contents []byte) (*Config, error) {
+	config := &Config{}
+	var current *section
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad config line %d: %q", lineNumber, line)
+			}
+			current = config.section(strings.ToLower(strings.TrimSpace(line[1:end])), true)
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("bad config line %d: key outside of a section", lineNumber)
+		}
+		key, value, found := strings.Cut(line, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			return nil, fmt.Errorf("bad config line %d: %q", lineNumber, line)
+		}
+		if !found {
+			// Git treats a bare key as a boolean true
+			value = "true"
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		current.values = append(current.values, keyValue{key: key, value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func splitKey(key string) (string, string) {
+	key = strings.ToLower(key)
+	index := strings.LastIndexByte(key, '.')
+	if index < 0 {
+		return "", key
+	}
+	return key[:index], key[index+1:]
+}
+
+func (c *Config) section(name string, create bool) *section {
+	for _, s := range c.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	if !create {
+		return nil
+	}
+	s := &section{name: name}
+	c.sections = append(c.sections, s)
+	return s
+}
+
+// Get returns the last value set for key, or "" when it is not set.
+func (c *Config) Get(key string) string {
+	sectionName, name := splitKey(key)
+	s := c.section(sectionName, false)
+	if s == nil {
+		return ""
+	}
+	value := ""
+	for _, kv := range s.values {
+		if kv.key == name {
+			value = kv.value
+		}
+	}
+	return value
+}
+
+func (c *Config) GetInt(key string, defaultValue int) (int, error) {
+	value := c.Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("bad numeric config value %q for %s", value, key)
+	}
+	return n, nil
+}
+
+func (c *Config) GetBool(key string, defaultValue bool) (bool, error) {
+	switch strings.ToLower(c.Get(key)) {
+	case "":
+		return defaultValue, nil
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("bad boolean config value %q for %s", c.Get(key), key)
+}
+
+// Set replaces every value of key with value.
+func (c *Config) Set(key string, value string) {
+	sectionName, name := splitKey(key)
+	s := c.section(sectionName, true)
+	values := s.values[:0]
+	for _, kv := range s.values {
+		if kv.key != name {
+			values = append(values, kv)
+		}
+	}
+	s.values = append(values, keyValue{key: name, value: value})
+}
+
+func (c *Config) Bytes() []byte {
+	var buffer bytes.Buffer
+	for _, s := range c.sections {
+		fmt.Fprintf(&buffer, "[%s]\n", s.name)
+		for _, kv := range s.values {
+			fmt.Fprintf(&buffer, "\t%s = %s\n", kv.key, kv.value)
+		}
+	}
+	return buffer.Bytes()
+}
+
+// Save writes the config through a lock file so readers never see a partial file.
+func (c *Config) Save() error {
+	fileName := GetConfigFileName()
+	lockFileName := fileName + ".lock"
+	file, err := os.OpenFile(lockFileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(c.Bytes()); err != nil {
+		file.Close()
+		os.Remove(lockFileName)
+		return err
+	}
+	// 設定ファイルは書き込みが稀なので、core.fsyncに関わらず常に同期する
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(lockFileName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(lockFileName)
+		return err
+	}
+	return os.Rename(lockFileName, fileName)
+}
+
+var current *Config
+
+// Current returns the repository config, loading it on first use.
+// A config file that cannot be parsed is an error rather than an empty
+// config: guessing the repository format would risk writing objects under
+// the wrong IDs.
+func Current() (*Config, error) {
+	if current == nil {
+		config, err := Load()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read config: %w", err)
+		}
+		current = config
+	}
+	return current, nil
+}
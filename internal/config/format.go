@@ -0,0 +1,25 @@
+package config
+
+import "fmt"
+
+// Repository format versions.
+// Version 0 is the original format: object IDs are the SHA-1 of the
+// compressed object. Version 1 hashes the uncompressed "type size\0body"
+// encoding, so IDs match the ones Git computes for the same content.
+const (
+	FORMAT_COMPRESSED_IDS = 0
+	FORMAT_GIT_IDS        = 1
+)
+
+// RepositoryFormatVersion returns core.repositoryformatversion. A version
+// this tool does not know is an error.
+func (c *Config) RepositoryFormatVersion() (int, error) {
+	version, err := c.GetInt("core.repositoryformatversion", FORMAT_COMPRESSED_IDS)
+	if err != nil {
+		return 0, err
+	}
+	if version != FORMAT_COMPRESSED_IDS && version != FORMAT_GIT_IDS {
+		return 0, fmt.Errorf("unknown repository format version %d", version)
+	}
+	return version, nil
+}
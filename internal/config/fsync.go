@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Components core.fsync can name. "objects" stands for loose objects and
+// packs, "all" for everything and "none" for nothing. Without the setting
+// everything is synced.
+const (
+	FSYNC_LOOSE_OBJECT = "loose-object"
+	FSYNC_PACK         = "pack"
+	FSYNC_INDEX        = "index"
+	FSYNC_REFERENCE    = "reference"
+	FSYNC_COMMIT_GRAPH = "commit-graph"
+)
+
+var fsyncAliases = map[string][]string{
+	"objects": {FSYNC_LOOSE_OBJECT, FSYNC_PACK},
+	"all":     {FSYNC_LOOSE_OBJECT, FSYNC_PACK, FSYNC_INDEX, FSYNC_REFERENCE, FSYNC_COMMIT_GRAPH},
+	"none":    {},
+}
+
+// Fsync reports whether files of component must reach the disk before they
+// are renamed into place. An unknown component in core.fsync is an error.
+func (c *Config) Fsync(component string) (bool, error) {
+	value := c.Get("core.fsync")
+	if value == "" {
+		return true, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		components, ok := fsyncAliases[name]
+		if !ok {
+			switch name {
+			case FSYNC_LOOSE_OBJECT, FSYNC_PACK, FSYNC_INDEX, FSYNC_REFERENCE, FSYNC_COMMIT_GRAPH:
+				components = []string{name}
+			default:
+				return false, fmt.Errorf("unknown core.fsync component %q", name)
+			}
+		}
+		for _, enabled := range components {
+			if enabled == component {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,84 @@
+// Package diff compares texts line by line.
+package diff
+
+import "strings"
+
+// SplitLines splits text into lines, keeping no line terminators. A final
+// line without a newline counts as a line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Match pairs a line of the old text with the equal line of the new text.
+type Match struct {
+	Old int
+	New int
+}
+
+// Lines returns the lines old and new have in common, in order, as found by
+// Myers' O(ND) algorithm: the shortest edit script keeps the most lines.
+func Lines(old []string, new []string) []Match {
+	n, m := len(old), len(new)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && old[x] == new[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v...))
+	}
+
+	// 最後から辿って、斜めに進んだ部分(一致した行)を集める
+	var matches []Match
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0 && (x > 0 || y > 0); d-- {
+		k := x - y
+		var prevK int
+		if d == 0 {
+			prevK = 0
+		} else {
+			previous := trace[d-1]
+			if k == -d || (k != d && previous[offset+k-1] < previous[offset+k+1]) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+		}
+		var prevX, prevY int
+		if d > 0 {
+			prevX = trace[d-1][offset+prevK]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, Match{Old: x, New: y})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
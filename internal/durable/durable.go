@@ -0,0 +1,62 @@
+// Package durable moves finished files into place so that a crash leaves
+// either the old file or the complete new one, never a truncated file.
+package durable
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/marutaku/go-git/internal/config"
+)
+
+// fsync reports whether core.fsync covers component.
+func fsync(component string) (bool, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return false, err
+	}
+	return repositoryConfig.Fsync(component)
+}
+
+// SyncFile flushes file to disk when core.fsync covers component.
+func SyncFile(file *os.File, component string) error {
+	sync, err := fsync(component)
+	if err != nil || !sync {
+		return err
+	}
+	return file.Sync()
+}
+
+// SyncDir flushes a directory, making renames into it durable, when
+// core.fsync covers component.
+func SyncDir(dir string, component string) error {
+	sync, err := fsync(component)
+	if err != nil || !sync {
+		return err
+	}
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// CommitFile syncs and closes a temporary or lock file written next to
+// target, then renames it over target. The file is removed on failure.
+func CommitFile(file *os.File, target string, component string) error {
+	if err := SyncFile(file, component); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), target); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return SyncDir(filepath.Dir(target), component)
+}
@@ -0,0 +1,190 @@
+// Package ewah implements the word-aligned hybrid bitmap compression Git
+// uses in .bitmap files. Bitmaps are kept uncompressed in memory and only
+// compressed when they are written.
+package ewah
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var ErrCorrupt = errors.New("corrupt ewah bitmap")
+
+// A run length word starts every block of the compressed form: bit 0 is the
+// value of the run, the next 32 bits its length in words and the top 31 bits
+// the number of literal words that follow it.
+const (
+	RUNNING_LENGTH_BITS = 32
+	LITERAL_BITS        = 64 - 1 - RUNNING_LENGTH_BITS
+	MAX_RUNNING_LENGTH  = 1<<RUNNING_LENGTH_BITS - 1
+	MAX_LITERAL_WORDS   = 1<<LITERAL_BITS - 1
+)
+
+// Bitmap is an uncompressed bitmap; bit i lives in word i/64.
+type Bitmap struct {
+	words []uint64
+	size  int
+}
+
+func New() *Bitmap {
+	return &Bitmap{}
+}
+
+// Size is one past the highest bit ever set, as recorded in the file.
+func (b *Bitmap) Size() int {
+	return b.size
+}
+
+func (b *Bitmap) Set(i int) {
+	for i/64 >= len(b.words) {
+		b.words = append(b.words, 0)
+	}
+	b.words[i/64] |= 1 << (i % 64)
+	if i >= b.size {
+		b.size = i + 1
+	}
+}
+
+func (b *Bitmap) Get(i int) bool {
+	return i/64 < len(b.words) && b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Or sets every bit set in other.
+func (b *Bitmap) Or(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] |= word
+	}
+	if other.size > b.size {
+		b.size = other.size
+	}
+}
+
+// Xor flips every bit set in other.
+func (b *Bitmap) Xor(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] ^= word
+	}
+	if other.size > b.size {
+		b.size = other.size
+	}
+}
+
+func (b *Bitmap) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// ForEach calls fn with every set bit in increasing order.
+func (b *Bitmap) ForEach(fn func(i int)) {
+	for w, word := range b.words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			fn(w*64 + bit)
+			word &= word - 1
+		}
+	}
+}
+
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: append([]uint64(nil), b.words...), size: b.size}
+}
+
+// compress returns the run length encoded words and the position of the
+// last run length word.
+func (b *Bitmap) compress() ([]uint64, int) {
+	words := b.words[:(b.size+63)/64]
+	var out []uint64
+	rlw := 0
+	i := 0
+	for i < len(words) || len(out) == 0 {
+		rlw = len(out)
+		out = append(out, 0)
+		var header uint64
+		if i < len(words) && (words[i] == 0 || words[i] == ^uint64(0)) {
+			clean := words[i]
+			run := uint64(0)
+			for i < len(words) && words[i] == clean && run < MAX_RUNNING_LENGTH {
+				run++
+				i++
+			}
+			if clean != 0 {
+				header |= 1
+			}
+			header |= run << 1
+		}
+		literals := uint64(0)
+		for i < len(words) && words[i] != 0 && words[i] != ^uint64(0) && literals < MAX_LITERAL_WORDS {
+			out = append(out, words[i])
+			literals++
+			i++
+		}
+		out[rlw] = header | literals<<(1+RUNNING_LENGTH_BITS)
+	}
+	return out, rlw
+}
+
+// WriteTo writes the bitmap in Git's serialized form: the size in bits, the
+// number of compressed words, the words and the position of the last run
+// length word, all big-endian.
+func (b *Bitmap) WriteTo(w io.Writer) (int64, error) {
+	words, rlw := b.compress()
+	buffer := make([]byte, 0, 8+len(words)*8+4)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(b.size))
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(words)))
+	for _, word := range words {
+		buffer = binary.BigEndian.AppendUint64(buffer, word)
+	}
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(rlw))
+	n, err := w.Write(buffer)
+	return int64(n), err
+}
+
+// Read decodes a serialized bitmap from the start of data and returns it
+// with the number of bytes consumed.
+func Read(data []byte) (*Bitmap, int, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrCorrupt
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	end := 8 + count*8 + 4
+	if count < 0 || end > len(data) || end < 8 {
+		return nil, 0, ErrCorrupt
+	}
+	b := &Bitmap{words: make([]uint64, 0, (size+63)/64), size: size}
+	for i := 0; i < count; {
+		header := binary.BigEndian.Uint64(data[8+i*8:])
+		i++
+		var clean uint64
+		if header&1 != 0 {
+			clean = ^uint64(0)
+		}
+		run := (header >> 1) & MAX_RUNNING_LENGTH
+		literals := int(header >> (1 + RUNNING_LENGTH_BITS))
+		for ; run > 0; run-- {
+			b.words = append(b.words, clean)
+		}
+		if i+literals > count {
+			return nil, 0, ErrCorrupt
+		}
+		for ; literals > 0; literals-- {
+			b.words = append(b.words, binary.BigEndian.Uint64(data[8+i*8:]))
+			i++
+		}
+	}
+	if len(b.words)*64 < size {
+		return nil, 0, ErrCorrupt
+	}
+	return b, end, nil
+}
@@ -0,0 +1,101 @@
+package ewah
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func bitmapOf(bits ...int) *Bitmap {
+	b := New()
+	for _, bit := range bits {
+		b.Set(bit)
+	}
+	return b
+}
+
+func setBits(b *Bitmap) []int {
+	var bits []int
+	b.ForEach(func(i int) { bits = append(bits, i) })
+	return bits
+}
+
+func TestRoundTrip(t *testing.T) {
+	full := New()
+	for i := 0; i < 64*5; i++ {
+		full.Set(i)
+	}
+	tests := []struct {
+		name   string
+		bitmap *Bitmap
+	}{
+		{"empty", New()},
+		{"first bit", bitmapOf(0)},
+		{"word boundary", bitmapOf(63, 64)},
+		{"sparse", bitmapOf(1, 1000, 100000)},
+		{"clean ones", full},
+		{"ones then literal", bitmapOf(append(setBits(full), 64*5+3)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if _, err := test.bitmap.WriteTo(&buffer); err != nil {
+				t.Fatal(err)
+			}
+			buffer.WriteString("trailing")
+			read, n, err := Read(buffer.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != buffer.Len()-len("trailing") {
+				t.Errorf("Read consumed %d bytes, want %d", n, buffer.Len()-len("trailing"))
+			}
+			if read.Size() != test.bitmap.Size() {
+				t.Errorf("Size = %d, want %d", read.Size(), test.bitmap.Size())
+			}
+			if got, want := setBits(read), setBits(test.bitmap); !slices.Equal(got, want) {
+				t.Errorf("bits = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestOrXor(t *testing.T) {
+	a := bitmapOf(1, 2, 200)
+	b := bitmapOf(2, 3)
+	or := a.Clone()
+	or.Or(b)
+	if got := setBits(or); !slices.Equal(got, []int{1, 2, 3, 200}) {
+		t.Errorf("Or = %v", got)
+	}
+	xor := b.Clone()
+	xor.Xor(a)
+	if got := setBits(xor); !slices.Equal(got, []int{1, 3, 200}) {
+		t.Errorf("Xor = %v", got)
+	}
+	if a.Count() != 3 {
+		t.Errorf("Clone shares words with the original")
+	}
+}
+
+func TestReadRejectsCorruptBitmaps(t *testing.T) {
+	var buffer bytes.Buffer
+	bitmapOf(5, 500).WriteTo(&buffer)
+	valid := buffer.Bytes()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", valid[:4]},
+		{"truncated words", valid[:len(valid)-8]},
+		{"size beyond words", append([]byte{0, 0, 0x10, 0}, valid[4:]...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := Read(test.data); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("Read = %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package hash
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	stdhash "hash"
+
+	"github.com/marutaku/go-git/internal/config"
+)
+
+// Algorithm is a hash function objects can be named with.
+type Algorithm struct {
+	Name string
+	Size int
+	// FormatID identifies the algorithm in binary formats such as the
+	// multi-pack-index and commit-graph headers.
+	FormatID uint8
+	New      func() stdhash.Hash
+}
+
+var SHA1 = &Algorithm{Name: "sha1", Size: sha1.Size, FormatID: 1, New: sha1.New}
+var SHA256 = &Algorithm{Name: "sha256", Size: sha256.Size, FormatID: 2, New: sha256.New}
+
+func AlgorithmByName(name string) (*Algorithm, bool) {
+	switch name {
+	case SHA1.Name:
+		return SHA1, true
+	case SHA256.Name:
+		return SHA256, true
+	}
+	return nil, false
+}
+
+// Current returns the algorithm the repository was created with, recorded
+// as extensions.objectformat. Repositories without it use SHA-1. It fails
+// when the repository format or the algorithm is one we do not know.
+func Current() (*Algorithm, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return nil, err
+	}
+	version, err := repositoryConfig.RepositoryFormatVersion()
+	if err != nil {
+		return nil, err
+	}
+	name := repositoryConfig.Get("extensions.objectformat")
+	if name == "" {
+		return SHA1, nil
+	}
+	algorithm, ok := AlgorithmByName(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown object format %q", name)
+	}
+	if version < config.FORMAT_GIT_IDS {
+		return nil, fmt.Errorf("extensions.objectformat requires repository format version %d", config.FORMAT_GIT_IDS)
+	}
+	return algorithm, nil
+}
+
+// current is Current for the helpers below, which cannot fail. Object reads
+// and writes check Current first, so a bad config is reported before an ID
+// of the wrong size is used; until then SHA-1 is assumed.
+func current() *Algorithm {
+	algorithm, err := Current()
+	if err != nil {
+		return SHA1
+	}
+	return algorithm
+}
+
+// Size is the length in bytes of object IDs in this repository.
+func Size() int {
+	return current().Size
+}
+
+// HexSize is the length of object IDs written in hex.
+func HexSize() int {
+	return current().Size * 2
+}
+
+// New returns a hash of the repository's algorithm.
+func New() stdhash.Hash {
+	return current().New()
+}
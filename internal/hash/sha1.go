@@ -1,39 +1,88 @@
 package hash
 
 import (
-	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/fs"
 
+	"github.com/marutaku/go-git/internal/config"
 	"github.com/marutaku/go-git/internal/utils"
 )
 
-func CalculateSha1HashFromFileStat(stat fs.FileInfo, fileContent []byte) ([]byte, error) {
-	contents := []byte(fmt.Sprintf("blob %d", uint32(stat.Size())))
-	contents = append(contents, 0)
-	contents = append(contents, []byte(fileContent)...)
-	compressed, err := utils.Compress(contents)
+// UncompressedObjectIDs reports whether object IDs are computed from the
+// uncompressed object, as Git does, rather than from its compressed form.
+// An unreadable config or format version counts as the original format;
+// Current reports it.
+func UncompressedObjectIDs() bool {
+	repositoryConfig, err := config.Current()
 	if err != nil {
+		return false
+	}
+	version, err := repositoryConfig.RepositoryFormatVersion()
+	return err == nil && version >= config.FORMAT_GIT_IDS
+}
+
+func CalculateSha1HashFromFileStat(stat fs.FileInfo, file io.Reader) ([]byte, error) {
+	return CalculateSha1HashFromReader("blob", stat.Size(), file)
+}
+
+// CalculateSha1HashFromReader hashes an object of the given type whose body is
+// read from r, without holding the body in memory.
+func CalculateSha1HashFromReader(objType string, size int64, r io.Reader) ([]byte, error) {
+	h := New()
+	var writer io.WriteCloser = nopWriteCloser{h}
+	if !UncompressedObjectIDs() {
+		writer = utils.NewCompressWriter(h)
+	}
+	if _, err := fmt.Fprintf(writer, "%s %d\x00", objType, size); err != nil {
 		return nil, err
 	}
-	h := sha1.New()
-	h.Write(compressed)
-	sha1Bytes := h.Sum(nil)
-	return sha1Bytes, nil
+	written, err := io.Copy(writer, r)
+	if err != nil {
+		return nil, err
+	}
+	if written != size {
+		return nil, fmt.Errorf("short read: expected %d bytes, got %d", size, written)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// CalculateObjectHash returns the ID of an object given both its encoding
+// ("type size\0body") and the compressed form of that encoding.
+func CalculateObjectHash(contents []byte, compressed []byte) ([]byte, error) {
+	if UncompressedObjectIDs() {
+		return CalculateSha1HashFromFileFromByte(contents)
+	}
+	return CalculateSha1HashFromFileFromByte(compressed)
 }
 
 func CalculateSha1HashFromFileFromByte(fileContent []byte) ([]byte, error) {
-	h := sha1.New()
+	h := New()
 	h.Write(fileContent)
 	sha1Bytes := h.Sum(nil)
 	return sha1Bytes, nil
 }
 
+// GetSha1Hex decodes a full object ID written in hex.
 func GetSha1Hex(sha1Hash string) ([]byte, error) {
 	bytes, err := hex.DecodeString(sha1Hash)
 	if err != nil {
 		return nil, err
 	}
+	if len(bytes) != Size() {
+		return nil, fmt.Errorf("object name %q is not %d hex digits long", sha1Hash, HexSize())
+	}
 	return bytes, nil
 }
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,17 @@
+package object
+
+type Blob struct {
+	Data []byte
+}
+
+func ParseBlob(body []byte) *Blob {
+	return &Blob{Data: body}
+}
+
+func (b *Blob) Type() string {
+	return "blob"
+}
+
+func (b *Blob) Encode() []byte {
+	return b.Data
+}
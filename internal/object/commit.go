@@ -0,0 +1,143 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Header is an extra commit header such as "encoding" or "gpgsig". Values
+// spanning several lines are stored with the newlines but without the
+// leading space of continuation lines.
+type Header struct {
+	Name  string
+	Value string
+}
+
+type Commit struct {
+	Tree         []byte
+	Parents      [][]byte
+	Author       *Signature
+	Committer    *Signature
+	ExtraHeaders []Header
+	Message      string
+}
+
+func (c *Commit) Type() string {
+	return "commit"
+}
+
+// splitHeaders splits the header lines of a commit or tag from its message.
+// Continuation lines, which start with a space, are joined to the line
+// before them.
+func splitHeaders(body []byte) ([][]byte, []byte, bool) {
+	var lines [][]byte
+	for len(body) > 0 {
+		line, rest, found := bytes.Cut(body, []byte{'\n'})
+		if !found {
+			return nil, nil, false
+		}
+		body = rest
+		if len(line) == 0 {
+			return lines, body, true
+		}
+		if line[0] == ' ' {
+			if len(lines) == 0 {
+				return nil, nil, false
+			}
+			last := len(lines) - 1
+			lines[last] = append(append(append([]byte(nil), lines[last]...), '\n'), line[1:]...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil, true
+}
+
+func ParseCommit(body []byte) (*Commit, error) {
+	lines, message, ok := splitHeaders(body)
+	if !ok {
+		return nil, parseError("commit", "badCommitHeader")
+	}
+	commit := &Commit{Message: string(message)}
+	next := func(field string) ([]byte, bool) {
+		if len(lines) == 0 {
+			return nil, false
+		}
+		value, found := bytes.CutPrefix(lines[0], []byte(field+" "))
+		if !found {
+			return nil, false
+		}
+		lines = lines[1:]
+		return value, true
+	}
+	value, found := next("tree")
+	if !found {
+		return nil, parseError("commit", "missingTree")
+	}
+	if commit.Tree, ok = parseID(value); !ok {
+		return nil, parseError("commit", "badTreeSha1")
+	}
+	for {
+		value, found := next("parent")
+		if !found {
+			break
+		}
+		parent, ok := parseID(value)
+		if !ok {
+			return nil, parseError("commit", "badParentSha1")
+		}
+		commit.Parents = append(commit.Parents, parent)
+	}
+	for _, field := range []struct {
+		name, missing, bad string
+		signature          **Signature
+	}{
+		{"author", "missingAuthor", "badAuthor", &commit.Author},
+		{"committer", "missingCommitter", "badCommitter", &commit.Committer},
+	} {
+		value, found := next(field.name)
+		if !found {
+			return nil, parseError("commit", field.missing)
+		}
+		if *field.signature, ok = ParseSignature(value); !ok {
+			return nil, parseError("commit", field.bad)
+		}
+	}
+	for _, line := range lines {
+		name, value, found := bytes.Cut(line, []byte{' '})
+		if !found || len(name) == 0 {
+			return nil, parseError("commit", "badCommitHeader")
+		}
+		switch string(name) {
+		case "tree", "parent", "author", "committer":
+			return nil, parseError("commit", "badCommitHeader")
+		}
+		commit.ExtraHeaders = append(commit.ExtraHeaders, Header{Name: string(name), Value: string(value)})
+	}
+	return commit, nil
+}
+
+// writeHeader writes one header line, continuing multi-line values on
+// lines that start with a space.
+func writeHeader(body *bytes.Buffer, name string, value string) {
+	body.WriteString(name)
+	body.WriteByte(' ')
+	body.Write(bytes.ReplaceAll([]byte(value), []byte{'\n'}, []byte("\n ")))
+	body.WriteByte('\n')
+}
+
+func (c *Commit) Encode() []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "tree %x\n", c.Tree)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&body, "parent %x\n", parent)
+	}
+	fmt.Fprintf(&body, "author %s\n", c.Author)
+	fmt.Fprintf(&body, "committer %s\n", c.Committer)
+	for _, header := range c.ExtraHeaders {
+		writeHeader(&body, header.Name, header.Value)
+	}
+	body.WriteByte('\n')
+	body.WriteString(c.Message)
+	return body.Bytes()
+}
@@ -0,0 +1,131 @@
+// Package object defines the typed objects stored in a repository, with
+// strict parsers and canonical encoders. Storage lives in package objects.
+package object
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+type Object interface {
+	Type() string
+	// Encode returns the canonical body of the object, without the
+	// "type size\0" header.
+	Encode() []byte
+}
+
+// ParseError describes malformed object data. Reason is a short identifier
+// in the style of Git's fsck messages, such as "badTree" or "missingAuthor".
+type ParseError struct {
+	Type   string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("malformed %s: %s", e.Type, e.Reason)
+}
+
+func parseError(nodeType string, reason string) error {
+	return &ParseError{Type: nodeType, Reason: reason}
+}
+
+func Parse(nodeType string, body []byte) (Object, error) {
+	switch nodeType {
+	case "blob":
+		return ParseBlob(body), nil
+	case "tree":
+		return ParseTree(body)
+	case "commit":
+		return ParseCommit(body)
+	case "tag":
+		return ParseTag(body)
+	}
+	return nil, fmt.Errorf("unknown object type %q", nodeType)
+}
+
+func Read(sha1 []byte) (Object, error) {
+	nodeType, body, err := objects.ReadSha1File(sha1)
+	if err != nil {
+		return nil, err
+	}
+	o, err := Parse(nodeType, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %x: %w", nodeType, sha1, err)
+	}
+	return o, nil
+}
+
+func readTyped(sha1 []byte, nodeType string) ([]byte, error) {
+	actualType, body, err := objects.ReadSha1File(sha1)
+	if err != nil {
+		return nil, err
+	}
+	if actualType != nodeType {
+		return nil, fmt.Errorf("object %x is a %s, not a %s", sha1, actualType, nodeType)
+	}
+	return body, nil
+}
+
+func ReadTree(sha1 []byte) (*Tree, error) {
+	body, err := readTyped(sha1, "tree")
+	if err != nil {
+		return nil, err
+	}
+	tree, err := ParseTree(body)
+	if err != nil {
+		return nil, fmt.Errorf("tree %x: %w", sha1, err)
+	}
+	return tree, nil
+}
+
+func ReadCommit(sha1 []byte) (*Commit, error) {
+	body, err := readTyped(sha1, "commit")
+	if err != nil {
+		return nil, err
+	}
+	commit, err := ParseCommit(body)
+	if err != nil {
+		return nil, fmt.Errorf("commit %x: %w", sha1, err)
+	}
+	return commit, nil
+}
+
+func ReadTag(sha1 []byte) (*Tag, error) {
+	body, err := readTyped(sha1, "tag")
+	if err != nil {
+		return nil, err
+	}
+	tag, err := ParseTag(body)
+	if err != nil {
+		return nil, fmt.Errorf("tag %x: %w", sha1, err)
+	}
+	return tag, nil
+}
+
+// Write stores the canonical encoding of o and returns its ID.
+func Write(o Object) ([]byte, error) {
+	body := o.Encode()
+	return objects.WriteSha1Stream(o.Type(), int64(len(body)), bytes.NewReader(body))
+}
+
+// parseID decodes an object ID written in hex in a header line. Only the
+// canonical lowercase spelling is accepted, as Git's fsck requires.
+func parseID(value []byte) ([]byte, bool) {
+	if len(value) != 2*hash.Size() {
+		return nil, false
+	}
+	for _, c := range value {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return nil, false
+		}
+	}
+	sha1, err := hex.DecodeString(string(value))
+	if err != nil {
+		return nil, false
+	}
+	return sha1, true
+}
@@ -0,0 +1,141 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	treeHex   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	parentHex = "0123456789abcdef0123456789abcdef01234567"
+	author    = "A U Thor <author@example.com> 1700000000 +0900"
+)
+
+// The bodies below are canonical: parsing and encoding them again must give
+// back the same bytes, or the object ID would change.
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodeType string
+		body     string
+	}{
+		{"blob", "blob", "any\x00bytes\n"},
+		{"root commit", "commit", "tree " + treeHex + "\nauthor " + author + "\ncommitter " + author + "\n\nmessage\n"},
+		{"merge", "commit", "tree " + treeHex + "\nparent " + parentHex + "\nparent " + treeHex + "\nauthor " + author + "\ncommitter " + author + "\n\nmerge\n"},
+		{"extra headers", "commit", "tree " + treeHex + "\nauthor " + author + "\ncommitter " + author + "\nencoding ISO-8859-1\ngpgsig -----BEGIN-----\n line\n -----END-----\n\nsigned\n"},
+		{"empty message", "commit", "tree " + treeHex + "\nauthor " + author + "\ncommitter " + author + "\n\n"},
+		{"tag", "tag", "object " + parentHex + "\ntype commit\ntag v1.0\ntagger " + author + "\n\nrelease\n"},
+		{"tag without tagger", "tag", "object " + parentHex + "\ntype blob\ntag old\n\n"},
+		{"tree", "tree", "100644 a\x00" + strings.Repeat("\x11", 20) + "40000 a-dir\x00" + strings.Repeat("\x22", 20) + "120000 link\x00" + strings.Repeat("\x33", 20)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, err := Parse(test.nodeType, []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.Type() != test.nodeType {
+				t.Errorf("Type = %q, want %q", o.Type(), test.nodeType)
+			}
+			if encoded := o.Encode(); !bytes.Equal(encoded, []byte(test.body)) {
+				t.Errorf("Encode = %q, want %q", encoded, test.body)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	header := "tree " + treeHex + "\nauthor " + author + "\ncommitter " + author + "\n"
+	tests := []struct {
+		name     string
+		nodeType string
+		body     string
+		reason   string
+	}{
+		{"missing tree", "commit", "author " + author + "\ncommitter " + author + "\n\n", "missingTree"},
+		{"uppercase tree", "commit", "tree " + strings.ToUpper(treeHex) + "\nauthor " + author + "\ncommitter " + author + "\n\n", "badTreeSha1"},
+		{"short tree", "commit", "tree " + treeHex[:39] + "\nauthor " + author + "\ncommitter " + author + "\n\n", "badTreeSha1"},
+		{"bad parent", "commit", "tree " + treeHex + "\nparent xyz\nauthor " + author + "\ncommitter " + author + "\n\n", "badParentSha1"},
+		{"missing author", "commit", "tree " + treeHex + "\ncommitter " + author + "\n\n", "missingAuthor"},
+		{"bad committer", "commit", "tree " + treeHex + "\nauthor " + author + "\ncommitter nobody\n\n", "badCommitter"},
+		{"duplicate tree", "commit", header + "tree " + treeHex + "\n\n", "badCommitHeader"},
+		{"unterminated header", "commit", "tree " + treeHex, "badCommitHeader"},
+		{"tag without object", "tag", "type commit\ntag v1\n\n", "missingObject"},
+		{"tag with bad type", "tag", "object " + parentHex + "\ntype thing\ntag v1\n\n", "badType"},
+		{"tag without name", "tag", "object " + parentHex + "\ntype commit\ntag \n\n", "badTagName"},
+		{"tag with bad tagger", "tag", "object " + parentHex + "\ntype commit\ntag v1\ntagger nobody\n\n", "badTagger"},
+		{"tree entry cut short", "tree", "100644 a\x00" + strings.Repeat("\x11", 19), "badTree"},
+		{"tree mode with leading zero", "tree", "040000 a\x00" + strings.Repeat("\x11", 20), "badFilemode"},
+		{"tree entry without name", "tree", "100644 \x00" + strings.Repeat("\x11", 20), "badTree"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.nodeType, []byte(test.body))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse = %v, want a ParseError", err)
+			}
+			if parseErr.Reason != test.reason {
+				t.Errorf("Reason = %q, want %q", parseErr.Reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		value  string
+		ok     bool
+		unix   int64
+		offset int
+	}{
+		{"A U Thor <a@example.com> 1700000000 +0900", true, 1700000000, 9 * 3600},
+		{"A U Thor <a@example.com> 1700000000 -0130", true, 1700000000, -90 * 60},
+		{"A U Thor <a@example.com> 1700000000", true, 1700000000, 0},
+		{"<a@example.com> 1700000000 +0000", false, 0, 0},
+		{"A U Thor a@example.com 1700000000 +0000", false, 0, 0},
+		{"A U Thor <a@example.com> -5 +0000", false, 0, 0},
+		{"A U Thor <a@example.com> 1700000000 +09", false, 0, 0},
+		{"A U Thor <a@example.com> 1700000000 +0960", false, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			signature, ok := ParseSignature([]byte(test.value))
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			_, offset := signature.When.Zone()
+			if signature.When.Unix() != test.unix || offset != test.offset {
+				t.Errorf("When = %v", signature.When)
+			}
+		})
+	}
+}
+
+func TestTreeEncodeSorts(t *testing.T) {
+	tree := &Tree{Entries: []*TreeEntry{
+		{Mode: 0100644, Name: "a.txt", Sha1: bytes.Repeat([]byte{1}, 20)},
+		{Mode: 0040000, Name: "a", Sha1: bytes.Repeat([]byte{2}, 20)},
+		{Mode: 0100644, Name: "a-b", Sha1: bytes.Repeat([]byte{3}, 20)},
+		{Mode: 0160000, Name: "module", Sha1: bytes.Repeat([]byte{4}, 20)},
+	}}
+	parsed, err := ParseTree(tree.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ツリーは名前の後ろに"/"があるものとして並ぶ
+	want := []string{"a-b", "a.txt", "a", "module"}
+	for i, entry := range parsed.Entries {
+		if entry.Name != want[i] {
+			t.Errorf("entry %d = %q, want %q", i, entry.Name, want[i])
+		}
+	}
+	if !parsed.Entries[2].IsTree() || !parsed.Entries[3].IsGitlink() {
+		t.Errorf("entry modes were not kept")
+	}
+}
@@ -0,0 +1,71 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Signature is the identity and time on an author, committer or tagger line:
+// "Name <email> <unix seconds> <+hhmm>".
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// ParseSignature accepts a missing time zone, which early commit-tree
+// versions did not write, and reads the time as UTC.
+func ParseSignature(value []byte) (*Signature, bool) {
+	emailStart := bytes.IndexByte(value, '<')
+	emailEnd := bytes.IndexByte(value, '>')
+	if emailStart < 1 || emailEnd < emailStart || value[emailStart-1] != ' ' {
+		return nil, false
+	}
+	name := value[:emailStart-1]
+	email := value[emailStart+1 : emailEnd]
+	if bytes.ContainsAny(name, "<>\n") || bytes.ContainsAny(email, "<\n") {
+		return nil, false
+	}
+	rest, found := bytes.CutPrefix(value[emailEnd+1:], []byte{' '})
+	if !found {
+		return nil, false
+	}
+	seconds, zone, hasZone := bytes.Cut(rest, []byte{' '})
+	unix, err := strconv.ParseInt(string(seconds), 10, 64)
+	if err != nil || seconds[0] == '+' || seconds[0] == '-' {
+		return nil, false
+	}
+	location := time.UTC
+	if hasZone {
+		if len(zone) != 5 || (zone[0] != '+' && zone[0] != '-') {
+			return nil, false
+		}
+		hours, hoursErr := strconv.Atoi(string(zone[1:3]))
+		minutes, minutesErr := strconv.Atoi(string(zone[3:5]))
+		if hoursErr != nil || minutesErr != nil || minutes >= 60 {
+			return nil, false
+		}
+		offset := hours*3600 + minutes*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone(string(zone), offset)
+	}
+	return &Signature{
+		Name:  string(name),
+		Email: string(email),
+		When:  time.Unix(unix, 0).In(location),
+	}, true
+}
+
+func (s *Signature) String() string {
+	_, offset := s.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", s.Name, s.Email, s.When.Unix(), sign, offset/3600, offset%3600/60)
+}
@@ -0,0 +1,131 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+// Tag is an annotated tag. Tags made before taggers were recorded have a nil
+// Tagger.
+type Tag struct {
+	Object []byte
+	// TargetType is the type of Object, recorded on the "type" line.
+	TargetType string
+	Tag        string
+	Tagger     *Signature
+	Message    string
+}
+
+func (t *Tag) Type() string {
+	return "tag"
+}
+
+func ParseTag(body []byte) (*Tag, error) {
+	lines, message, ok := splitHeaders(body)
+	if !ok {
+		return nil, parseError("tag", "badTagHeader")
+	}
+	tag := &Tag{Message: string(message)}
+	field := func(i int, name string) ([]byte, bool) {
+		if i >= len(lines) {
+			return nil, false
+		}
+		return bytes.CutPrefix(lines[i], []byte(name+" "))
+	}
+	value, found := field(0, "object")
+	if !found {
+		return nil, parseError("tag", "missingObject")
+	}
+	if tag.Object, ok = parseID(value); !ok {
+		return nil, parseError("tag", "badObjectSha1")
+	}
+	value, found = field(1, "type")
+	if !found {
+		return nil, parseError("tag", "missingTypeEntry")
+	}
+	switch string(value) {
+	case "blob", "tree", "commit", "tag":
+		tag.TargetType = string(value)
+	default:
+		return nil, parseError("tag", "badType")
+	}
+	value, found = field(2, "tag")
+	if !found {
+		return nil, parseError("tag", "missingTagEntry")
+	}
+	if len(value) == 0 {
+		return nil, parseError("tag", "badTagName")
+	}
+	tag.Tag = string(value)
+	rest := lines[3:]
+	if len(rest) > 0 {
+		value, found := bytes.CutPrefix(rest[0], []byte("tagger "))
+		if !found {
+			return nil, parseError("tag", "badTagHeader")
+		}
+		if tag.Tagger, ok = ParseSignature(value); !ok {
+			return nil, parseError("tag", "badTagger")
+		}
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return nil, parseError("tag", "badTagHeader")
+	}
+	return tag, nil
+}
+
+func (t *Tag) Encode() []byte {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "object %x\ntype %s\ntag %s\n", t.Object, t.TargetType, t.Tag)
+	if t.Tagger != nil {
+		fmt.Fprintf(&body, "tagger %s\n", t.Tagger)
+	}
+	body.WriteByte('\n')
+	body.WriteString(t.Message)
+	return body.Bytes()
+}
+
+// CheckTarget verifies that the tagged object exists and has the type the
+// tag declares.
+func (t *Tag) CheckTarget() error {
+	nodeType, _, err := objects.ReadSha1Header(t.Object)
+	if err != nil {
+		return fmt.Errorf("tagged object %x: %w", t.Object, err)
+	}
+	if nodeType != t.TargetType {
+		return fmt.Errorf("tagged object %x is a %s, not a %s", t.Object, nodeType, t.TargetType)
+	}
+	return nil
+}
+
+// Peel follows tags from sha1 until it reaches an object of nodeType. A
+// commit peels to its tree.
+func Peel(sha1 []byte, nodeType string) ([]byte, error) {
+	for {
+		actualType, _, err := objects.ReadSha1Header(sha1)
+		if err != nil {
+			return nil, err
+		}
+		if actualType == nodeType {
+			return sha1, nil
+		}
+		switch {
+		case actualType == "tag":
+			tag, err := ReadTag(sha1)
+			if err != nil {
+				return nil, err
+			}
+			sha1 = tag.Object
+		case actualType == "commit" && nodeType == "tree":
+			commit, err := ReadCommit(sha1)
+			if err != nil {
+				return nil, err
+			}
+			sha1 = commit.Tree
+		default:
+			return nil, fmt.Errorf("object %x is a %s, not a %s", sha1, actualType, nodeType)
+		}
+	}
+}
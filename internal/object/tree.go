@@ -0,0 +1,93 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Sha1 []byte
+}
+
+// IsTree reports whether a tree entry mode points at another tree.
+func (e *TreeEntry) IsTree() bool {
+	return e.Mode&0170000 == 0040000
+}
+
+// IsGitlink reports whether a tree entry points at a commit of another repository.
+func (e *TreeEntry) IsGitlink() bool {
+	return e.Mode&0170000 == 0160000
+}
+
+// SortName is the name Git sorts a tree entry by: trees sort as if their
+// name ended with a slash.
+func (e *TreeEntry) SortName() string {
+	if e.IsTree() {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+type Tree struct {
+	Entries []*TreeEntry
+}
+
+func (t *Tree) Type() string {
+	return "tree"
+}
+
+// ParseTree splits a tree body into entries. Names may contain slashes, as
+// write-tree records full index paths in a single tree.
+func ParseTree(body []byte) (*Tree, error) {
+	hashSize := hash.Size()
+	tree := &Tree{}
+	offset := 0
+	for offset < len(body) {
+		nullByteIndex := bytes.IndexByte(body[offset:], 0)
+		if nullByteIndex < 0 || offset+nullByteIndex+1+hashSize > len(body) {
+			return nil, parseError("tree", "badTree")
+		}
+		modeString, name, found := bytes.Cut(body[offset:offset+nullByteIndex], []byte{' '})
+		if !found || len(name) == 0 {
+			return nil, parseError("tree", "badTree")
+		}
+		mode, err := strconv.ParseUint(string(modeString), 8, 32)
+		if err != nil || modeString[0] == '0' {
+			return nil, parseError("tree", "badFilemode")
+		}
+		offset += nullByteIndex + 1
+		tree.Entries = append(tree.Entries, &TreeEntry{
+			Mode: uint32(mode),
+			Name: string(name),
+			Sha1: body[offset : offset+hashSize],
+		})
+		offset += hashSize
+	}
+	return tree, nil
+}
+
+// Sort puts the entries in the order Git requires of a tree.
+func (t *Tree) Sort() {
+	sort.SliceStable(t.Entries, func(i, j int) bool {
+		return t.Entries[i].SortName() < t.Entries[j].SortName()
+	})
+}
+
+// Encode writes the entries sorted, with modes in octal without leading
+// zeros.
+func (t *Tree) Encode() []byte {
+	entries := &Tree{Entries: append([]*TreeEntry(nil), t.Entries...)}
+	entries.Sort()
+	var body bytes.Buffer
+	for _, entry := range entries.Entries {
+		fmt.Fprintf(&body, "%o %s\x00", entry.Mode, entry.Name)
+		body.Write(entry.Sha1)
+	}
+	return body.Bytes()
+}
@@ -0,0 +1,126 @@
+package objects
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// MIN_ABBREV is the shortest prefix accepted as an object name;
+// DEFAULT_ABBREV is the length abbreviations start from.
+const (
+	MIN_ABBREV     = 4
+	DEFAULT_ABBREV = 7
+)
+
+// AmbiguousError is returned for a prefix shared by several objects.
+type AmbiguousError struct {
+	Prefix     string
+	Candidates [][]byte
+}
+
+func (e *AmbiguousError) Error() string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "short object ID %s is ambiguous\ncandidates:", e.Prefix)
+	for _, candidate := range e.Candidates {
+		nodeType, _, err := ReadSha1Header(candidate)
+		if err != nil {
+			nodeType = "unknown"
+		}
+		fmt.Fprintf(&message, "\n  %x %s", candidate, nodeType)
+	}
+	return message.String()
+}
+
+// findPrefix returns the distinct objects whose hex ID starts with prefix,
+// in ID order.
+func findPrefix(prefix string) ([][]byte, error) {
+	seen := map[string]bool{}
+	var matches [][]byte
+	add := func(sha1 []byte) {
+		if !seen[string(sha1)] {
+			seen[string(sha1)] = true
+			matches = append(matches, append([]byte(nil), sha1...))
+		}
+	}
+	var err error
+	if store, ok := GetStore().(*RepositoryStore); ok {
+		err = store.FindPrefix(prefix, add)
+	} else {
+		err = GetStore().Iterate(func(sha1 []byte) error {
+			if strings.HasPrefix(hex.EncodeToString(sha1), prefix) {
+				add(sha1)
+			}
+			return nil
+		})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return bytes.Compare(matches[i], matches[j]) < 0
+	})
+	return matches, err
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveSha1Hex turns a full or abbreviated hex object name into an ID.
+// Full names are decoded without checking that the object exists; a prefix
+// of at least MIN_ABBREV characters must name exactly one object.
+func ResolveSha1Hex(name string) ([]byte, error) {
+	name = strings.ToLower(name)
+	if len(name) == hash.HexSize() {
+		return hash.GetSha1Hex(name)
+	}
+	if len(name) < MIN_ABBREV || len(name) > hash.HexSize() || !isHex(name) {
+		return nil, fmt.Errorf("invalid object name %q", name)
+	}
+	matches, err := findPrefix(name)
+	if err != nil {
+		return nil, err
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%s: %w", name, ErrObjectNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	return nil, &AmbiguousError{Prefix: name, Candidates: matches}
+}
+
+// Abbreviate returns the shortest prefix of sha1, at least minLength
+// characters long, that no other object shares.
+func Abbreviate(sha1 []byte, minLength int) (string, error) {
+	full := hex.EncodeToString(sha1)
+	if minLength < MIN_ABBREV {
+		minLength = MIN_ABBREV
+	}
+	if minLength >= len(full) {
+		return full, nil
+	}
+	matches, err := findPrefix(full[:minLength])
+	if err != nil {
+		return "", err
+	}
+	length := minLength
+	for _, match := range matches {
+		other := hex.EncodeToString(match)
+		common := 0
+		for common < len(full) && full[common] == other[common] {
+			common++
+		}
+		if common < len(full) && common+1 > length {
+			length = common + 1
+		}
+	}
+	return full[:length], nil
+}
@@ -0,0 +1,38 @@
+package objects
+
+import (
+	"compress/zlib"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+// LooseCompression returns the codec and level new loose objects are
+// written with, from core.objectcodec and core.compression. Repositories
+// whose object IDs hash the compressed bytes always use default zlib, as any
+// other setting would change the IDs. Reads detect the codec, so objects
+// written under an earlier setting stay readable. An invalid setting is
+// returned as an error.
+func LooseCompression() (utils.Compression, error) {
+	if !hash.UncompressedObjectIDs() {
+		return utils.DefaultCompression, nil
+	}
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return utils.Compression{}, err
+	}
+	compression := utils.Compression{Codec: repositoryConfig.Get("core.objectcodec")}
+	if compression.Codec == "" {
+		compression.Codec = utils.CODEC_ZLIB
+	}
+	level, err := repositoryConfig.GetInt("core.compression", zlib.DefaultCompression)
+	if err != nil {
+		return utils.Compression{}, err
+	}
+	compression.Level = level
+	if err := compression.Validate(); err != nil {
+		return utils.Compression{}, err
+	}
+	return compression, nil
+}
@@ -0,0 +1,230 @@
+package objects
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/pack"
+)
+
+// ObjectInfo describes one stored object. Pack is empty for loose objects.
+type ObjectInfo struct {
+	Sha1 []byte
+	Type string
+	Size int64
+	Pack string
+}
+
+// ObjectFilter limits ForEachObject. Empty Types allows every type and a
+// zero MaxSize no upper bound, so the zero value matches everything.
+// LooseOnly and PackedOnly restrict where objects are looked for.
+type ObjectFilter struct {
+	Types      []string
+	MinSize    int64
+	MaxSize    int64
+	LooseOnly  bool
+	PackedOnly bool
+}
+
+func (f *ObjectFilter) match(info *ObjectInfo) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Types) > 0 {
+		found := false
+		for _, objType := range f.Types {
+			if objType == info.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return info.Size >= f.MinSize && (f.MaxSize <= 0 || info.Size <= f.MaxSize)
+}
+
+func objectInfo(store ObjectStore, sha1 []byte) (*ObjectInfo, error) {
+	reader, err := store.Open(sha1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return &ObjectInfo{Sha1: sha1, Type: reader.Type, Size: reader.Size}, nil
+}
+
+// ForEachObject calls fn with every local object that passes filter, loose
+// objects first. An object both loose and packed is reported once, as loose,
+// unless filter asks for packed objects only. A nil filter passes everything.
+func ForEachObject(filter *ObjectFilter, fn func(info *ObjectInfo) error) error {
+	store := GetStore()
+	repository, ok := store.(*RepositoryStore)
+	if !ok {
+		return store.Iterate(func(sha1 []byte) error {
+			info, err := objectInfo(store, sha1)
+			if err != nil {
+				return err
+			}
+			if !filter.match(info) {
+				return nil
+			}
+			return fn(info)
+		})
+	}
+	seen := map[string]bool{}
+	err := repository.Loose.Iterate(func(sha1 []byte) error {
+		seen[string(sha1)] = true
+		if filter != nil && filter.PackedOnly {
+			return nil
+		}
+		info, err := objectInfo(repository.Loose, sha1)
+		if err != nil {
+			return err
+		}
+		if !filter.match(info) {
+			return nil
+		}
+		return fn(info)
+	})
+	if err != nil || (filter != nil && filter.LooseOnly) {
+		return err
+	}
+	if err := repository.Packs.Reload(); err != nil {
+		return err
+	}
+	for _, p := range repository.Packs.Packs() {
+		for _, i := range p.Index.PackOrder() {
+			sha1 := p.Index.Sha1(i)
+			if seen[string(sha1)] {
+				continue
+			}
+			seen[string(sha1)] = true
+			// パック順に読むとデルタのベースがキャッシュに残りやすい
+			objType, size, reader, err := p.OpenAt(p.Index.Offset(i))
+			if err != nil {
+				return fmt.Errorf("%s: %x: %w", p.Name, sha1, err)
+			}
+			reader.Close()
+			info := &ObjectInfo{Sha1: sha1, Type: pack.TypeName(objType), Size: size, Pack: p.Name}
+			if !filter.match(info) {
+				continue
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DiskUsage returns the space a file takes on disk, which for small loose
+// objects is more than their size.
+func DiskUsage(stat os.FileInfo) int64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return sys.Blocks * 512
+	}
+	return stat.Size()
+}
+
+// PACK_FILE_EXTENSIONS are the files that may sit next to a .pack.
+var PACK_FILE_EXTENSIONS = []string{".pack", ".idx", ".bitmap", ".keep", ".rev", ".promisor", ".mtimes"}
+
+// Garbage calls fn with every file in the object directory that is not an
+// object, a pack or a file belonging to one: leftover temporary files, files
+// with bad names in the fan-out directories and pack files missing their
+// .pack or .idx.
+func (s *LooseStore) Garbage(fn func(path string, stat os.FileInfo) error) error {
+	// オブジェクトディレクトリ直下にはtmp_obj_*などのファイルしか残らない
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := fn(filepath.Join(s.dir, entry.Name()), stat); err != nil {
+			return err
+		}
+	}
+	hashSize := hash.Size()
+	for i := 0; i < 256; i++ {
+		dir := fmt.Sprintf("%s/%02x", s.dir, i)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			sha1, err := hex.DecodeString(fmt.Sprintf("%02x%s", i, entry.Name()))
+			if err == nil && len(sha1) == hashSize && entry.Type().IsRegular() {
+				continue
+			}
+			stat, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := fn(filepath.Join(dir, entry.Name()), stat); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Garbage is LooseStore.Garbage for a pack directory.
+func (s *PackStore) Garbage(fn func(path string, stat os.FileInfo) error) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	names := map[string]bool{}
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == pack.MIDX_FILE_NAME {
+			continue
+		}
+		extension := filepath.Ext(name)
+		base := strings.TrimSuffix(name, extension)
+		known := false
+		for _, allowed := range PACK_FILE_EXTENSIONS {
+			if extension == allowed {
+				known = strings.HasPrefix(name, "pack-")
+				break
+			}
+		}
+		// .packと.idxが揃っていないものは使われない
+		if known && !(names[base+".pack"] && names[base+".idx"]) {
+			known = false
+		}
+		if known {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := fn(filepath.Join(s.dir, name), stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
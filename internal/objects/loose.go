@@ -0,0 +1,144 @@
+package objects
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// LooseStore keeps one compressed file per object under <objectsDir>/xx/.
+type LooseStore struct {
+	dir string
+}
+
+func NewLooseStore(objectsDir string) *LooseStore {
+	return &LooseStore{dir: objectsDir}
+}
+
+// FileName returns where sha1 is or would be stored.
+func (s *LooseStore) FileName(sha1 []byte) string {
+	sha1Str := fmt.Sprintf("%x", sha1)
+	return fmt.Sprintf("%s/%s/%s", s.dir, sha1Str[:2], sha1Str[2:])
+}
+
+func (s *LooseStore) Has(sha1 []byte) bool {
+	_, err := os.Stat(s.FileName(sha1))
+	return err == nil
+}
+
+func (s *LooseStore) Read(sha1 []byte) (string, []byte, error) {
+	return readAll(s.Open(sha1))
+}
+
+func (s *LooseStore) Open(sha1 []byte) (*ObjectReader, error) {
+	file, err := os.Open(s.FileName(sha1))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%x: %w", sha1, ErrObjectNotFound)
+		}
+		return nil, err
+	}
+	return newObjectReader(file)
+}
+
+// Write stores an already compressed object through a temporary file, so
+// an interrupted write never leaves a truncated object under its final name.
+func (s *LooseStore) Write(sha1 []byte, buffer []byte) error {
+	writer, err := s.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(buffer); err != nil {
+		writer.Abort()
+		return err
+	}
+	return writer.Commit(sha1)
+}
+
+func (s *LooseStore) Create() (ObjectWriter, error) {
+	file, err := os.CreateTemp(s.dir, "tmp_obj_")
+	if err != nil {
+		return nil, err
+	}
+	return &looseObjectWriter{store: s, file: file}, nil
+}
+
+func (s *LooseStore) Delete(sha1 []byte) error {
+	return os.Remove(s.FileName(sha1))
+}
+
+func (s *LooseStore) Iterate(fn func(sha1 []byte) error) error {
+	hashSize := hash.Size()
+	for i := 0; i < 256; i++ {
+		dir := fmt.Sprintf("%s/%02x", s.dir, i)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, entry := range entries {
+			sha1, err := hex.DecodeString(fmt.Sprintf("%02x%s", i, entry.Name()))
+			if err != nil || len(sha1) != hashSize {
+				// 一時ファイルなどオブジェクト以外のファイルは無視する
+				continue
+			}
+			if err := fn(sha1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FindPrefix calls fn with every object whose hex ID starts with prefix,
+// which must be at least two characters long.
+func (s *LooseStore) FindPrefix(prefix string, fn func(sha1 []byte)) error {
+	entries, err := os.ReadDir(fmt.Sprintf("%s/%s", s.dir, prefix[:2]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	hashSize := hash.Size()
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix[2:]) {
+			continue
+		}
+		sha1, err := hex.DecodeString(prefix[:2] + entry.Name())
+		if err == nil && len(sha1) == hashSize {
+			fn(sha1)
+		}
+	}
+	return nil
+}
+
+// looseObjectWriter writes into a temporary file next to the fan-out
+// directories and renames it into place on Commit.
+type looseObjectWriter struct {
+	store *LooseStore
+	file  *os.File
+}
+
+func (w *looseObjectWriter) Write(p []byte) (int, error) {
+	return w.file.Write(p)
+}
+
+func (w *looseObjectWriter) Commit(sha1 []byte) error {
+	if w.store.Has(sha1) {
+		return w.Abort()
+	}
+	return durable.CommitFile(w.file, w.store.FileName(sha1), config.FSYNC_LOOSE_OBJECT)
+}
+
+func (w *looseObjectWriter) Abort() error {
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
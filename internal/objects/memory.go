@@ -0,0 +1,99 @@
+package objects
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps compressed objects in memory. It is meant for tests and
+// services that should not touch the filesystem.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Has(sha1 []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.objects[string(sha1)]
+	return ok
+}
+
+func (s *MemoryStore) Read(sha1 []byte) (string, []byte, error) {
+	return readAll(s.Open(sha1))
+}
+
+func (s *MemoryStore) Open(sha1 []byte) (*ObjectReader, error) {
+	s.mu.RLock()
+	buffer, ok := s.objects[string(sha1)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%x: %w", sha1, ErrObjectNotFound)
+	}
+	return newObjectReader(io.NopCloser(bytes.NewReader(buffer)))
+}
+
+func (s *MemoryStore) Write(sha1 []byte, buffer []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[string(sha1)]; ok {
+		return nil
+	}
+	s.objects[string(sha1)] = append([]byte(nil), buffer...)
+	return nil
+}
+
+func (s *MemoryStore) Create() (ObjectWriter, error) {
+	return &memoryObjectWriter{store: s}, nil
+}
+
+func (s *MemoryStore) Delete(sha1 []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[string(sha1)]; !ok {
+		return fmt.Errorf("%x: %w", sha1, ErrObjectNotFound)
+	}
+	delete(s.objects, string(sha1))
+	return nil
+}
+
+func (s *MemoryStore) Iterate(fn func(sha1 []byte) error) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryObjectWriter struct {
+	store  *MemoryStore
+	buffer bytes.Buffer
+}
+
+func (w *memoryObjectWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+func (w *memoryObjectWriter) Commit(sha1 []byte) error {
+	return w.store.Write(sha1, w.buffer.Bytes())
+}
+
+func (w *memoryObjectWriter) Abort() error {
+	w.buffer.Reset()
+	return nil
+}
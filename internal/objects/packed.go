@@ -0,0 +1,311 @@
+package objects
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/pack"
+)
+
+var ErrReadOnlyStore = errors.New("object store is read-only")
+
+// PackStore reads objects from the packs in a directory. New packs are
+// picked up the first time a lookup misses. When the directory has a
+// multi-pack-index, objects of the packs it covers are found through it.
+type PackStore struct {
+	dir    string
+	packs  []*pack.Packfile
+	opened map[string]bool
+	midx   *multiPackIndex
+	// ExternalBase resolves REF_DELTA bases that live outside the pack.
+	ExternalBase func(sha1 []byte) (string, []byte, error)
+}
+
+func NewPackStore(dir string) *PackStore {
+	return &PackStore{dir: dir, opened: map[string]bool{}}
+}
+
+// Reload opens packs that appeared since the last scan.
+func (s *PackStore) Reload() error {
+	idxFiles, err := filepath.Glob(filepath.Join(s.dir, "*.idx"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(idxFiles)
+	for _, idxFile := range idxFiles {
+		name := strings.TrimSuffix(idxFile, ".idx")
+		if s.opened[name] {
+			continue
+		}
+		if _, err := os.Stat(name + ".pack"); err != nil {
+			// .packのない.idxは書き込み途中か壊れているので使わない
+			continue
+		}
+		p, err := pack.Open(name)
+		if err != nil {
+			return err
+		}
+		p.ExternalBase = s.externalBase
+		s.opened[name] = true
+		s.packs = append(s.packs, p)
+	}
+	return s.reloadMultiPackIndex()
+}
+
+// multiPackIndex is a loaded multi-pack-index with its packs resolved.
+type multiPackIndex struct {
+	*pack.MultiPackIndex
+	modTime time.Time
+	packs   []*pack.Packfile
+	covered map[*pack.Packfile]bool
+}
+
+// reloadMultiPackIndex (re)reads the multi-pack-index when it changed. One
+// that names a pack which is not open is stale and ignored.
+func (s *PackStore) reloadMultiPackIndex() error {
+	fileName := filepath.Join(s.dir, pack.MIDX_FILE_NAME)
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		s.midx = nil
+		return nil
+	}
+	if s.midx != nil && s.midx.modTime.Equal(stat.ModTime()) {
+		return nil
+	}
+	s.midx = nil
+	midx, err := pack.ReadMultiPackIndex(fileName)
+	if err != nil {
+		log.Printf("warning: ignoring multi-pack-index: %v", err)
+		return nil
+	}
+	loaded := &multiPackIndex{MultiPackIndex: midx, modTime: stat.ModTime(), covered: map[*pack.Packfile]bool{}}
+	byName := map[string]*pack.Packfile{}
+	for _, p := range s.packs {
+		byName[filepath.Base(p.Name)+".idx"] = p
+	}
+	for _, name := range midx.PackNames {
+		p, ok := byName[name]
+		if !ok {
+			return nil
+		}
+		loaded.packs = append(loaded.packs, p)
+		loaded.covered[p] = true
+	}
+	s.midx = loaded
+	return nil
+}
+
+// MultiPackIndex returns the multi-pack-index in use, or nil.
+func (s *PackStore) MultiPackIndex() *pack.MultiPackIndex {
+	if s.midx == nil {
+		return nil
+	}
+	return s.midx.MultiPackIndex
+}
+
+// Dir returns the pack directory.
+func (s *PackStore) Dir() string {
+	return s.dir
+}
+
+// HasMultiPackIndex reports whether the directory has a multi-pack-index
+// file, in use or not.
+func (s *PackStore) HasMultiPackIndex() bool {
+	_, err := os.Stat(filepath.Join(s.dir, pack.MIDX_FILE_NAME))
+	return err == nil
+}
+
+// WriteMultiPackIndex replaces the multi-pack-index with one covering every
+// pack in the directory.
+func (s *PackStore) WriteMultiPackIndex() error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.dir, "tmp_midx_")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = pack.WriteMultiPackIndex(writer, s.packs)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := durable.CommitFile(file, filepath.Join(s.dir, pack.MIDX_FILE_NAME), config.FSYNC_PACK); err != nil {
+		return err
+	}
+	s.midx = nil
+	return s.reloadMultiPackIndex()
+}
+
+func (s *PackStore) externalBase(sha1 []byte) (int, []byte, error) {
+	if p, offset := s.locate(sha1); p != nil {
+		return p.ReadAt(offset)
+	}
+	if s.ExternalBase == nil {
+		return 0, nil, fmt.Errorf("%x: %w", sha1, ErrObjectNotFound)
+	}
+	nodeType, body, err := s.ExternalBase(sha1)
+	if err != nil {
+		return 0, nil, err
+	}
+	objType, err := pack.TypeFromName(nodeType)
+	return objType, body, err
+}
+
+// Packs returns the packs currently open.
+func (s *PackStore) Packs() []*pack.Packfile {
+	return s.packs
+}
+
+// Remove closes a pack and deletes its files. The .bitmap and .idx go first
+// so that no reader picks up an index whose pack is gone.
+func (s *PackStore) Remove(p *pack.Packfile) error {
+	for i, opened := range s.packs {
+		if opened == p {
+			s.packs = append(s.packs[:i], s.packs[i+1:]...)
+			break
+		}
+	}
+	delete(s.opened, p.Name)
+	if s.midx != nil && s.midx.covered[p] {
+		s.midx = nil
+	}
+	p.Close()
+	if err := os.Remove(p.Name + ".bitmap"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(p.Name + ".idx"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(p.Name + ".pack"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Find returns the open pack holding sha1, or nil.
+func (s *PackStore) Find(sha1 []byte) *pack.Packfile {
+	p, _ := s.locate(sha1)
+	return p
+}
+
+// locate returns the pack holding sha1 and the offset of its entry. The
+// multi-pack-index is asked first; only packs it does not cover are searched
+// one by one.
+func (s *PackStore) locate(sha1 []byte) (*pack.Packfile, int64) {
+	if s.midx != nil {
+		if packID, offset, ok := s.midx.Find(sha1); ok && packID < len(s.midx.packs) {
+			return s.midx.packs[packID], offset
+		}
+	}
+	for _, p := range s.packs {
+		if s.midx != nil && s.midx.covered[p] {
+			continue
+		}
+		if offset, ok := p.Index.Find(sha1); ok {
+			return p, offset
+		}
+	}
+	return nil, 0
+}
+
+func (s *PackStore) lookup(sha1 []byte) (*pack.Packfile, int64, error) {
+	if p, offset := s.locate(sha1); p != nil {
+		return p, offset, nil
+	}
+	if err := s.Reload(); err != nil {
+		return nil, 0, err
+	}
+	if p, offset := s.locate(sha1); p != nil {
+		return p, offset, nil
+	}
+	return nil, 0, fmt.Errorf("%x: %w", sha1, ErrObjectNotFound)
+}
+
+func (s *PackStore) Has(sha1 []byte) bool {
+	p, _, err := s.lookup(sha1)
+	return err == nil && p != nil
+}
+
+func (s *PackStore) Read(sha1 []byte) (string, []byte, error) {
+	p, offset, err := s.lookup(sha1)
+	if err != nil {
+		return "", nil, err
+	}
+	objType, body, err := p.ReadAt(offset)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	return pack.TypeName(objType), body, nil
+}
+
+func (s *PackStore) Open(sha1 []byte) (*ObjectReader, error) {
+	p, offset, err := s.lookup(sha1)
+	if err != nil {
+		return nil, err
+	}
+	objType, size, reader, err := p.OpenAt(offset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	return &ObjectReader{
+		Type:   pack.TypeName(objType),
+		Size:   size,
+		body:   &sizedReader{reader: reader, remaining: size},
+		closer: reader,
+	}, nil
+}
+
+func (s *PackStore) Write(sha1 []byte, buffer []byte) error {
+	return ErrReadOnlyStore
+}
+
+func (s *PackStore) Create() (ObjectWriter, error) {
+	return nil, ErrReadOnlyStore
+}
+
+func (s *PackStore) Delete(sha1 []byte) error {
+	return ErrReadOnlyStore
+}
+
+// FindPrefix calls fn with every packed object whose hex ID starts with
+// prefix.
+func (s *PackStore) FindPrefix(prefix string, fn func(sha1 []byte)) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	for _, p := range s.packs {
+		p.Index.FindPrefix(prefix, func(i int) {
+			fn(p.Index.Sha1(i))
+		})
+	}
+	return nil
+}
+
+func (s *PackStore) Iterate(fn func(sha1 []byte) error) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	for _, p := range s.packs {
+		for i := 0; i < p.Index.Count(); i++ {
+			if err := fn(p.Index.Sha1(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
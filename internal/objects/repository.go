@@ -0,0 +1,231 @@
+package objects
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// MAX_ALTERNATE_DEPTH limits how far alternates of alternates are followed.
+var MAX_ALTERNATE_DEPTH = 5
+
+// RepositoryStore looks objects up in the loose store first, then in packs,
+// then in the object directories listed in objects/info/alternates.
+// Writes and deletes only touch local loose objects, and Iterate only visits
+// local objects: borrowed objects belong to the repository they live in.
+type RepositoryStore struct {
+	Loose      *LooseStore
+	Packs      *PackStore
+	Alternates []*RepositoryStore
+	dir        string
+}
+
+// NewRepositoryStore opens the objects of the repository in dir.
+func NewRepositoryStore(dir string) *RepositoryStore {
+	return newObjectDirectoryStore(filepath.Join(dir, "objects"), 0, map[string]bool{})
+}
+
+func newObjectDirectoryStore(objectsDir string, depth int, seen map[string]bool) *RepositoryStore {
+	store := &RepositoryStore{
+		Loose: NewLooseStore(objectsDir),
+		Packs: NewPackStore(filepath.Join(objectsDir, "pack")),
+		dir:   objectsDir,
+	}
+	store.Packs.ExternalBase = store.Read
+	if absolute, err := filepath.Abs(objectsDir); err == nil {
+		seen[absolute] = true
+	}
+	if depth >= MAX_ALTERNATE_DEPTH {
+		return store
+	}
+	alternates, err := ReadAlternates(objectsDir)
+	if err != nil {
+		log.Printf("warning: ignoring alternates of %s: %v", objectsDir, err)
+		return store
+	}
+	for _, alternate := range alternates {
+		absolute, err := filepath.Abs(alternate)
+		if err != nil || seen[absolute] {
+			continue
+		}
+		if stat, err := os.Stat(alternate); err != nil || !stat.IsDir() {
+			log.Printf("warning: alternate object directory %s does not exist", alternate)
+			continue
+		}
+		store.Alternates = append(store.Alternates, newObjectDirectoryStore(alternate, depth+1, seen))
+	}
+	return store
+}
+
+func GetAlternatesFileName(objectsDir string) string {
+	return filepath.Join(objectsDir, "info", "alternates")
+}
+
+// ReadAlternates returns the object directories listed in
+// <objectsDir>/info/alternates. Relative paths are taken relative to
+// objectsDir, as Git does.
+func ReadAlternates(objectsDir string) ([]string, error) {
+	file, err := os.Open(GetAlternatesFileName(objectsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	var alternates []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objectsDir, line)
+		}
+		alternates = append(alternates, filepath.Clean(line))
+	}
+	return alternates, scanner.Err()
+}
+
+// HasLocal reports whether sha1 is stored in this repository itself rather
+// than borrowed from an alternate.
+func (s *RepositoryStore) HasLocal(sha1 []byte) bool {
+	return s.Loose.Has(sha1) || s.Packs.Has(sha1)
+}
+
+func (s *RepositoryStore) Has(sha1 []byte) bool {
+	if s.HasLocal(sha1) {
+		return true
+	}
+	for _, alternate := range s.Alternates {
+		if alternate.Has(sha1) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileName returns the loose file holding sha1, searching alternates when it
+// is not stored locally. It returns the local path when no loose copy exists.
+func (s *RepositoryStore) FileName(sha1 []byte) string {
+	if s.Loose.Has(sha1) {
+		return s.Loose.FileName(sha1)
+	}
+	for _, alternate := range s.Alternates {
+		if alternate.Loose.Has(sha1) {
+			return alternate.Loose.FileName(sha1)
+		}
+	}
+	return s.Loose.FileName(sha1)
+}
+
+func (s *RepositoryStore) Read(sha1 []byte) (string, []byte, error) {
+	return readAll(s.Open(sha1))
+}
+
+func (s *RepositoryStore) Open(sha1 []byte) (*ObjectReader, error) {
+	if _, err := hash.Current(); err != nil {
+		return nil, err
+	}
+	reader, err := s.Loose.Open(sha1)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return reader, err
+	}
+	reader, err = s.Packs.Open(sha1)
+	if !errors.Is(err, ErrObjectNotFound) {
+		return reader, err
+	}
+	for _, alternate := range s.Alternates {
+		reader, altErr := alternate.Open(sha1)
+		if !errors.Is(altErr, ErrObjectNotFound) {
+			return reader, altErr
+		}
+	}
+	return nil, err
+}
+
+// Write stores a loose object unless it is already available, including
+// from an alternate.
+func (s *RepositoryStore) Write(sha1 []byte, buffer []byte) error {
+	if _, err := hash.Current(); err != nil {
+		return err
+	}
+	if s.Packs.Find(sha1) != nil {
+		return nil
+	}
+	for _, alternate := range s.Alternates {
+		if alternate.Has(sha1) {
+			return nil
+		}
+	}
+	return s.Loose.Write(sha1, buffer)
+}
+
+func (s *RepositoryStore) Create() (ObjectWriter, error) {
+	if _, err := hash.Current(); err != nil {
+		return nil, err
+	}
+	writer, err := s.Loose.Create()
+	if err != nil {
+		return nil, err
+	}
+	return &repositoryObjectWriter{ObjectWriter: writer, store: s}, nil
+}
+
+// repositoryObjectWriter drops the new loose object when it turns out to be
+// packed or borrowed already.
+type repositoryObjectWriter struct {
+	ObjectWriter
+	store *RepositoryStore
+}
+
+func (w *repositoryObjectWriter) Commit(sha1 []byte) error {
+	if !w.store.Loose.Has(sha1) && w.store.Has(sha1) {
+		return w.Abort()
+	}
+	return w.ObjectWriter.Commit(sha1)
+}
+
+func (s *RepositoryStore) Delete(sha1 []byte) error {
+	return s.Loose.Delete(sha1)
+}
+
+// FindPrefix calls fn with every object, local or borrowed, whose hex ID
+// starts with prefix. An object stored in several places may be reported
+// more than once.
+func (s *RepositoryStore) FindPrefix(prefix string, fn func(sha1 []byte)) error {
+	if err := s.Loose.FindPrefix(prefix, fn); err != nil {
+		return err
+	}
+	if err := s.Packs.FindPrefix(prefix, fn); err != nil {
+		return err
+	}
+	for _, alternate := range s.Alternates {
+		if err := alternate.FindPrefix(prefix, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate visits every local object once, even when it is both loose and packed.
+func (s *RepositoryStore) Iterate(fn func(sha1 []byte) error) error {
+	seen := map[string]bool{}
+	visit := func(sha1 []byte) error {
+		if seen[string(sha1)] {
+			return nil
+		}
+		seen[string(sha1)] = true
+		return fn(sha1)
+	}
+	if err := s.Loose.Iterate(visit); err != nil {
+		return err
+	}
+	return s.Packs.Iterate(visit)
+}
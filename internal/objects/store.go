@@ -0,0 +1,38 @@
+package objects
+
+import (
+	"errors"
+
+	"github.com/marutaku/go-git/internal/env"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is the backend every object read and write goes through.
+// Buffers passed to Write are compressed objects as produced by WriteSha1File.
+// Open and Create are the streaming counterparts of Read and Write.
+type ObjectStore interface {
+	Has(sha1 []byte) bool
+	Read(sha1 []byte) (string, []byte, error)
+	Open(sha1 []byte) (*ObjectReader, error)
+	Write(sha1 []byte, buffer []byte) error
+	Create() (ObjectWriter, error)
+	Delete(sha1 []byte) error
+	Iterate(fn func(sha1 []byte) error) error
+}
+
+var currentStore ObjectStore
+
+// GetStore returns the store used by the package level helpers.
+// Unless SetStore was called, this is the loose and packed objects under
+// SHA1_FILE_DIRECTORY.
+func GetStore() ObjectStore {
+	if currentStore == nil {
+		currentStore = NewRepositoryStore(env.GetSHA1FileDirectory())
+	}
+	return currentStore
+}
+
+func SetStore(store ObjectStore) {
+	currentStore = store
+}
@@ -0,0 +1,201 @@
+package objects
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+// MAX_HEADER_SIZE bounds how much is inflated while looking for the end of
+// the "type size\0" header.
+var MAX_HEADER_SIZE = 64
+
+// ObjectReader streams the body of an object. Type and Size come from the
+// object header, so they are known before any of the body is inflated.
+type ObjectReader struct {
+	Type   string
+	Size   int64
+	body   io.Reader
+	closer io.Closer
+}
+
+func (r *ObjectReader) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+func (r *ObjectReader) Close() error {
+	return r.closer.Close()
+}
+
+// ObjectWriter receives a compressed object. The object only becomes visible
+// in the store once Commit is called with its ID.
+type ObjectWriter interface {
+	io.Writer
+	Commit(sha1 []byte) error
+	Abort() error
+}
+
+type multiCloser []io.Closer
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, closer := range c {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// newObjectReader inflates the header of a compressed object and leaves the
+// body to be inflated as it is read.
+func newObjectReader(compressed io.ReadCloser) (*ObjectReader, error) {
+	zr, err := utils.NewDecompressReader(bufio.NewReader(compressed))
+	if err != nil {
+		compressed.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(zr)
+	header := make([]byte, 0, MAX_HEADER_SIZE)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			zr.Close()
+			compressed.Close()
+			return nil, errors.New("object header not terminated")
+		}
+		if b == 0 {
+			break
+		}
+		if len(header) == MAX_HEADER_SIZE {
+			zr.Close()
+			compressed.Close()
+			return nil, errors.New("object header too long")
+		}
+		header = append(header, b)
+	}
+	var nodeType string
+	var bodySize int64
+	if _, err := fmt.Sscanf(string(header), "%s %d", &nodeType, &bodySize); err != nil || bodySize < 0 {
+		zr.Close()
+		compressed.Close()
+		return nil, fmt.Errorf("bad object header %q", header)
+	}
+	return &ObjectReader{
+		Type:   nodeType,
+		Size:   bodySize,
+		body:   &sizedReader{reader: reader, remaining: bodySize},
+		closer: multiCloser{zr, compressed},
+	}, nil
+}
+
+// sizedReader returns exactly remaining bytes, reporting a truncated body
+// as io.ErrUnexpectedEOF instead of a clean end of stream.
+type sizedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func readAll(reader *ObjectReader, err error) (string, []byte, error) {
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+	body := make([]byte, reader.Size)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return "", nil, err
+	}
+	return reader.Type, body, nil
+}
+
+// OpenSha1File opens an object for streaming. The caller must Close it.
+func OpenSha1File(sha1 []byte) (*ObjectReader, error) {
+	return GetStore().Open(sha1)
+}
+
+// ReadSha1Header returns the type and size of an object without inflating its body.
+func ReadSha1Header(sha1 []byte) (string, int64, error) {
+	reader, err := GetStore().Open(sha1)
+	if err != nil {
+		return "", 0, err
+	}
+	defer reader.Close()
+	return reader.Type, reader.Size, nil
+}
+
+// WriteSha1Stream compresses an object of the given type whose body is read
+// from r into the store and returns its ID. Only a bounded buffer is held in
+// memory regardless of size.
+func WriteSha1Stream(objType string, size int64, r io.Reader) ([]byte, error) {
+	return WriteSha1StreamTo(GetStore(), objType, size, r)
+}
+
+// WriteSha1StreamTo is WriteSha1Stream for a specific store.
+func WriteSha1StreamTo(store ObjectStore, objType string, size int64, r io.Reader) ([]byte, error) {
+	writer, err := store.Create()
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	var compressor io.WriteCloser
+	var input io.Writer
+	if hash.UncompressedObjectIDs() {
+		var compression utils.Compression
+		compression, err = LooseCompression()
+		if err == nil {
+			compressor, err = compression.NewWriter(writer)
+		}
+		if err != nil {
+			writer.Abort()
+			return nil, err
+		}
+		input = io.MultiWriter(compressor, h)
+	} else {
+		compressor = utils.NewCompressWriter(io.MultiWriter(writer, h))
+		input = compressor
+	}
+	if _, err := fmt.Fprintf(input, "%s %d\x00", objType, size); err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	written, err := io.Copy(input, r)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	if written != size {
+		writer.Abort()
+		return nil, fmt.Errorf("short read: expected %d bytes, got %d", size, written)
+	}
+	if err := compressor.Close(); err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	sha1 := h.Sum(nil)
+	if err := writer.Commit(sha1); err != nil {
+		return nil, err
+	}
+	return sha1, nil
+}
@@ -0,0 +1,53 @@
+package pack
+
+import "container/list"
+
+// DELTA_BASE_CACHE_LIMIT bounds the bytes kept by each pack's delta base cache.
+var DELTA_BASE_CACHE_LIMIT = 96 * 1024 * 1024
+
+// deltaBaseCache keeps recently used objects, keyed by pack offset, so
+// sibling deltas do not rebuild their shared base chain again.
+type deltaBaseCache struct {
+	limit   int
+	size    int
+	lru     *list.List
+	entries map[int64]*list.Element
+}
+
+type cachedObject struct {
+	offset  int64
+	objType int
+	data    []byte
+}
+
+func newDeltaBaseCache(limit int) *deltaBaseCache {
+	return &deltaBaseCache{limit: limit, lru: list.New(), entries: map[int64]*list.Element{}}
+}
+
+func (c *deltaBaseCache) get(offset int64) (int, []byte, bool) {
+	element, ok := c.entries[offset]
+	if !ok {
+		return 0, nil, false
+	}
+	c.lru.MoveToFront(element)
+	object := element.Value.(*cachedObject)
+	return object.objType, object.data, true
+}
+
+func (c *deltaBaseCache) add(offset int64, objType int, data []byte) {
+	if len(data) > c.limit {
+		return
+	}
+	if _, ok := c.entries[offset]; ok {
+		return
+	}
+	c.entries[offset] = c.lru.PushFront(&cachedObject{offset: offset, objType: objType, data: data})
+	c.size += len(data)
+	for c.size > c.limit {
+		oldest := c.lru.Back()
+		object := oldest.Value.(*cachedObject)
+		c.lru.Remove(oldest)
+		delete(c.entries, object.offset)
+		c.size -= len(object.data)
+	}
+}
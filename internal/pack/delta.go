@@ -0,0 +1,258 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+)
+
+var ErrBadDelta = errors.New("corrupt delta")
+
+func readDeltaSize(delta []byte, offset int) (int64, int, error) {
+	size := int64(0)
+	shift := uint(0)
+	for {
+		if offset >= len(delta) || shift > 56 {
+			return 0, 0, ErrBadDelta
+		}
+		b := delta[offset]
+		offset++
+		size |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, offset, nil
+		}
+	}
+}
+
+// DeltaResultSize returns the size of the object a delta produces without applying it.
+func DeltaResultSize(delta []byte) (int64, error) {
+	_, offset, err := readDeltaSize(delta, 0)
+	if err != nil {
+		return 0, err
+	}
+	size, _, err := readDeltaSize(delta, offset)
+	return size, err
+}
+
+// ApplyDelta rebuilds an object from its base and a delta made of Git's
+// copy and insert instructions.
+func ApplyDelta(base []byte, delta []byte) ([]byte, error) {
+	baseSize, offset, err := readDeltaSize(delta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != int64(len(base)) {
+		return nil, ErrBadDelta
+	}
+	resultSize, offset, err := readDeltaSize(delta, offset)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, 0, resultSize)
+	for offset < len(delta) {
+		cmd := delta[offset]
+		offset++
+		switch {
+		case cmd&0x80 != 0:
+			copyOffset, copySize := 0, 0
+			for i := uint(0); i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if offset >= len(delta) {
+						return nil, ErrBadDelta
+					}
+					copyOffset |= int(delta[offset]) << (8 * i)
+					offset++
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if offset >= len(delta) {
+						return nil, ErrBadDelta
+					}
+					copySize |= int(delta[offset]) << (8 * i)
+					offset++
+				}
+			}
+			if copySize == 0 {
+				copySize = 0x10000
+			}
+			if copyOffset+copySize > len(base) || int64(len(result)+copySize) > resultSize {
+				return nil, ErrBadDelta
+			}
+			result = append(result, base[copyOffset:copyOffset+copySize]...)
+		case cmd != 0:
+			size := int(cmd)
+			if offset+size > len(delta) || int64(len(result)+size) > resultSize {
+				return nil, ErrBadDelta
+			}
+			result = append(result, delta[offset:offset+size]...)
+			offset += size
+		default:
+			// 0は将来のために予約されている
+			return nil, ErrBadDelta
+		}
+	}
+	if int64(len(result)) != resultSize {
+		return nil, ErrBadDelta
+	}
+	return result, nil
+}
+
+// Delta encoding parameters. Copies are capped at 64KiB, the largest size
+// older Git versions accept in a single copy instruction.
+const (
+	DELTA_BLOCK_SIZE = 16
+	MAX_COPY_SIZE    = 0x10000
+	MAX_INSERT_SIZE  = 0x7f
+	MAX_BUCKET_SIZE  = 64
+	DELTA_HASH_PRIME = 0x01000193
+)
+
+// DeltaIndex indexes the blocks of a base object so that several targets
+// can be delta-encoded against it without rescanning it.
+type DeltaIndex struct {
+	base   []byte
+	blocks map[uint32][]int
+}
+
+var deltaHashPower = func() uint32 {
+	power := uint32(1)
+	for i := 0; i < DELTA_BLOCK_SIZE; i++ {
+		power *= DELTA_HASH_PRIME
+	}
+	return power
+}()
+
+func hashBlock(block []byte) uint32 {
+	h := uint32(0)
+	for _, b := range block[:DELTA_BLOCK_SIZE] {
+		h = h*DELTA_HASH_PRIME + uint32(b)
+	}
+	return h
+}
+
+func NewDeltaIndex(base []byte) *DeltaIndex {
+	index := &DeltaIndex{base: base, blocks: map[uint32][]int{}}
+	for offset := 0; offset+DELTA_BLOCK_SIZE <= len(base); offset += DELTA_BLOCK_SIZE {
+		h := hashBlock(base[offset:])
+		if len(index.blocks[h]) < MAX_BUCKET_SIZE {
+			index.blocks[h] = append(index.blocks[h], offset)
+		}
+	}
+	return index
+}
+
+func appendDeltaSize(buffer []byte, size int64) []byte {
+	for size >= 0x80 {
+		buffer = append(buffer, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(buffer, byte(size))
+}
+
+func appendInsert(buffer []byte, data []byte) []byte {
+	for len(data) > 0 {
+		size := len(data)
+		if size > MAX_INSERT_SIZE {
+			size = MAX_INSERT_SIZE
+		}
+		buffer = append(buffer, byte(size))
+		buffer = append(buffer, data[:size]...)
+		data = data[size:]
+	}
+	return buffer
+}
+
+func appendCopy(buffer []byte, offset int, size int) []byte {
+	for size > 0 {
+		chunk := size
+		if chunk > MAX_COPY_SIZE {
+			chunk = MAX_COPY_SIZE
+		}
+		cmdIndex := len(buffer)
+		cmd := byte(0x80)
+		buffer = append(buffer, 0)
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				cmd |= 1 << i
+				buffer = append(buffer, b)
+			}
+		}
+		// 0x10000はサイズのバイトを省略して表す
+		if chunk != MAX_COPY_SIZE {
+			for i := uint(0); i < 3; i++ {
+				if b := byte(chunk >> (8 * i)); b != 0 {
+					cmd |= 0x10 << i
+					buffer = append(buffer, b)
+				}
+			}
+		}
+		buffer[cmdIndex] = cmd
+		offset += chunk
+		size -= chunk
+	}
+	return buffer
+}
+
+// Diff encodes target as a delta against the indexed base. It gives up and
+// returns nil as soon as the delta grows beyond maxSize, when maxSize > 0.
+func (index *DeltaIndex) Diff(target []byte, maxSize int) []byte {
+	base := index.base
+	delta := appendDeltaSize(nil, int64(len(base)))
+	delta = appendDeltaSize(delta, int64(len(target)))
+	insertStart := 0
+	i := 0
+	h := uint32(0)
+	if len(target) >= DELTA_BLOCK_SIZE {
+		h = hashBlock(target)
+	}
+	for i+DELTA_BLOCK_SIZE <= len(target) {
+		bestOffset, bestLength, bestBack := 0, 0, 0
+		for _, offset := range index.blocks[h] {
+			if !bytes.Equal(base[offset:offset+DELTA_BLOCK_SIZE], target[i:i+DELTA_BLOCK_SIZE]) {
+				continue
+			}
+			length := DELTA_BLOCK_SIZE
+			for offset+length < len(base) && i+length < len(target) && base[offset+length] == target[i+length] {
+				length++
+			}
+			back := 0
+			for back < i-insertStart && back < offset && base[offset-back-1] == target[i-back-1] {
+				back++
+			}
+			if length+back > bestLength+bestBack {
+				bestOffset, bestLength, bestBack = offset, length, back
+			}
+		}
+		if bestLength == 0 {
+			if maxSize > 0 && len(delta)+i-insertStart > maxSize {
+				return nil
+			}
+			if i+DELTA_BLOCK_SIZE < len(target) {
+				h = (h*DELTA_HASH_PRIME + uint32(target[i+DELTA_BLOCK_SIZE])) - uint32(target[i])*deltaHashPower
+			}
+			i++
+			continue
+		}
+		delta = appendInsert(delta, target[insertStart:i-bestBack])
+		delta = appendCopy(delta, bestOffset-bestBack, bestLength+bestBack)
+		i += bestLength
+		insertStart = i
+		if i+DELTA_BLOCK_SIZE <= len(target) {
+			h = hashBlock(target[i:])
+		}
+		if maxSize > 0 && len(delta) > maxSize {
+			return nil
+		}
+	}
+	delta = appendInsert(delta, target[insertStart:])
+	if maxSize > 0 && len(delta) > maxSize {
+		return nil
+	}
+	return delta
+}
+
+// DiffDelta encodes target as a delta against base.
+func DiffDelta(base []byte, target []byte) []byte {
+	return NewDeltaIndex(base).Diff(target, 0)
+}
@@ -0,0 +1,95 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	large := randomBytes(1, 3*MAX_COPY_SIZE+100)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 50)
+	tests := []struct {
+		name   string
+		base   []byte
+		target []byte
+	}{
+		{"both empty", nil, nil},
+		{"empty base", nil, []byte("hello, world")},
+		{"empty target", text, nil},
+		{"identical", text, text},
+		{"base shorter than a block", []byte("short"), []byte("short but longer")},
+		{"insert in the middle", text, append(append(append([]byte(nil), text[:500]...), "inserted line\n"...), text[500:]...)},
+		{"prefix removed", text, text[700:]},
+		{"suffix appended", text, append(append([]byte(nil), text...), randomBytes(2, 300)...)},
+		{"unrelated", randomBytes(3, 1000), randomBytes(4, 1000)},
+		{"copy longer than 64KiB", large, append(append([]byte(nil), large...), "tail"...)},
+		{"copy at large offset", large, large[2*MAX_COPY_SIZE+7:]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			delta := DiffDelta(test.base, test.target)
+			size, err := DeltaResultSize(delta)
+			if err != nil {
+				t.Fatalf("DeltaResultSize: %v", err)
+			}
+			if size != int64(len(test.target)) {
+				t.Errorf("DeltaResultSize = %d, want %d", size, len(test.target))
+			}
+			result, err := ApplyDelta(test.base, delta)
+			if err != nil {
+				t.Fatalf("ApplyDelta: %v", err)
+			}
+			if !bytes.Equal(result, test.target) {
+				t.Errorf("ApplyDelta did not reproduce the target")
+			}
+		})
+	}
+}
+
+func TestDeltaCopiesFromBase(t *testing.T) {
+	base := randomBytes(5, 4096)
+	target := append(append([]byte(nil), base[:2000]...), base[2100:]...)
+	delta := DiffDelta(base, target)
+	if len(delta) >= len(target)/10 {
+		t.Errorf("delta of %d bytes for a %d byte target that only drops a range", len(delta), len(target))
+	}
+}
+
+func TestDiffMaxSize(t *testing.T) {
+	index := NewDeltaIndex(randomBytes(6, 1000))
+	if delta := index.Diff(randomBytes(7, 1000), 100); delta != nil {
+		t.Errorf("Diff returned %d bytes past maxSize", len(delta))
+	}
+}
+
+func TestApplyDeltaRejectsCorruptDeltas(t *testing.T) {
+	base := []byte("0123456789")
+	tests := []struct {
+		name  string
+		delta []byte
+	}{
+		{"empty", nil},
+		{"wrong base size", []byte{9, 1, 0x01, 'x'}},
+		{"truncated result size", []byte{10}},
+		{"reserved instruction", []byte{10, 1, 0x00}},
+		{"insert past the end", []byte{10, 5, 0x05, 'a', 'b'}},
+		{"copy outside the base", []byte{10, 4, 0x91, 8, 4}},
+		{"result longer than declared", []byte{10, 1, 0x02, 'a', 'b'}},
+		{"result shorter than declared", []byte{10, 3, 0x01, 'a'}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ApplyDelta(base, test.delta); !errors.Is(err, ErrBadDelta) {
+				t.Errorf("ApplyDelta = %v, want ErrBadDelta", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// Index is a parsed version 2 .idx file.
+type Index struct {
+	fanout       [256]uint32
+	names        []byte
+	crcs         []byte
+	offsets      []byte
+	largeOffsets []byte
+	PackChecksum []byte
+	hashSize     int
+}
+
+func ReadIndexFile(fileName string) (*Index, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := ParseIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return idx, nil
+}
+
+func ParseIndex(data []byte) (*Index, error) {
+	hashSize := hash.Size()
+	headerSize := 8 + 256*4
+	if len(data) < headerSize+2*hashSize {
+		return nil, errors.New("index file too small")
+	}
+	if !bytes.Equal(data[:4], IDX_SIGNATURE) {
+		return nil, errors.New("bad index signature")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != IDX_VERSION {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+	idx := &Index{hashSize: hashSize}
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[8+i*4:])
+		if i > 0 && idx.fanout[i] < idx.fanout[i-1] {
+			return nil, errors.New("index fanout table is not monotonic")
+		}
+	}
+	count := int(idx.fanout[255])
+	offset := headerSize
+	minimumSize := offset + count*(hashSize+4+4) + 2*hashSize
+	if len(data) < minimumSize {
+		return nil, errors.New("index file truncated")
+	}
+	idx.names = data[offset : offset+count*hashSize]
+	offset += count * hashSize
+	idx.crcs = data[offset : offset+count*4]
+	offset += count * 4
+	idx.offsets = data[offset : offset+count*4]
+	offset += count * 4
+	idx.largeOffsets = data[offset : len(data)-2*hashSize]
+	if len(idx.largeOffsets)%8 != 0 {
+		return nil, errors.New("index large offset table is malformed")
+	}
+	idx.PackChecksum = data[len(data)-2*hashSize : len(data)-hashSize]
+	return idx, nil
+}
+
+func (idx *Index) Count() int {
+	return int(idx.fanout[255])
+}
+
+func (idx *Index) Sha1(i int) []byte {
+	return idx.names[i*idx.hashSize : (i+1)*idx.hashSize]
+}
+
+func (idx *Index) CRC32(i int) uint32 {
+	return binary.BigEndian.Uint32(idx.crcs[i*4:])
+}
+
+func (idx *Index) Offset(i int) int64 {
+	offset := binary.BigEndian.Uint32(idx.offsets[i*4:])
+	if offset&LARGE_OFFSET_FLAG == 0 {
+		return int64(offset)
+	}
+	large := int(offset&^LARGE_OFFSET_FLAG) * 8
+	if large+8 > len(idx.largeOffsets) {
+		return -1
+	}
+	return int64(binary.BigEndian.Uint64(idx.largeOffsets[large:]))
+}
+
+// PackOrder returns the index positions sorted by offset, the order the
+// objects are stored in the pack.
+func (idx *Index) PackOrder() []int {
+	order := make([]int, idx.Count())
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return idx.Offset(order[a]) < idx.Offset(order[b])
+	})
+	return order
+}
+
+// FindIndex returns the position of sha1 in the index, using the fanout
+// table to narrow the binary search to names sharing the first byte.
+func (idx *Index) FindIndex(sha1 []byte) (int, bool) {
+	low := 0
+	if sha1[0] > 0 {
+		low = int(idx.fanout[sha1[0]-1])
+	}
+	high := int(idx.fanout[sha1[0]])
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(idx.Sha1(low+i), sha1) >= 0
+	})
+	if i < high && bytes.Equal(idx.Sha1(i), sha1) {
+		return i, true
+	}
+	return 0, false
+}
+
+func (idx *Index) Find(sha1 []byte) (int64, bool) {
+	i, ok := idx.FindIndex(sha1)
+	if !ok {
+		return 0, false
+	}
+	return idx.Offset(i), true
+}
+
+// FindPrefix calls fn with the position of every name starting with the
+// given lowercase hex prefix.
+func (idx *Index) FindPrefix(prefix string, fn func(i int)) {
+	padded := prefix
+	if len(padded)%2 == 1 {
+		padded += "0"
+	}
+	lower, err := hex.DecodeString(padded)
+	if err != nil || len(lower) == 0 {
+		return
+	}
+	low := 0
+	if lower[0] > 0 {
+		low = int(idx.fanout[lower[0]-1])
+	}
+	high := int(idx.fanout[lower[0]])
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(idx.Sha1(low+i), lower) >= 0
+	})
+	for ; i < high && strings.HasPrefix(hex.EncodeToString(idx.Sha1(i)), prefix); i++ {
+		fn(i)
+	}
+}
@@ -0,0 +1,65 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+var IDX_SIGNATURE = []byte{0xff, 't', 'O', 'c'}
+
+const IDX_VERSION = 2
+
+// Offsets at or above this value go to the 64-bit large offset table.
+const LARGE_OFFSET_FLAG = 0x80000000
+
+// WriteIndex writes a version 2 .idx for the entries of a pack.
+func WriteIndex(w io.Writer, entries []*Entry, packChecksum []byte) error {
+	sorted := make([]*Entry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Sha1, sorted[j].Sha1) < 0
+	})
+
+	h := hash.New()
+	out := io.MultiWriter(w, h)
+	buffer := append([]byte(nil), IDX_SIGNATURE...)
+	buffer = binary.BigEndian.AppendUint32(buffer, IDX_VERSION)
+
+	var fanout [256]uint32
+	for _, entry := range sorted {
+		fanout[entry.Sha1[0]]++
+	}
+	total := uint32(0)
+	for i := range fanout {
+		total += fanout[i]
+		buffer = binary.BigEndian.AppendUint32(buffer, total)
+	}
+	for _, entry := range sorted {
+		buffer = append(buffer, entry.Sha1...)
+	}
+	for _, entry := range sorted {
+		buffer = binary.BigEndian.AppendUint32(buffer, entry.CRC32)
+	}
+	var largeOffsets []byte
+	largeOffsetCount := uint32(0)
+	for _, entry := range sorted {
+		if entry.Offset < LARGE_OFFSET_FLAG {
+			buffer = binary.BigEndian.AppendUint32(buffer, uint32(entry.Offset))
+			continue
+		}
+		buffer = binary.BigEndian.AppendUint32(buffer, LARGE_OFFSET_FLAG|largeOffsetCount)
+		largeOffsets = binary.BigEndian.AppendUint64(largeOffsets, uint64(entry.Offset))
+		largeOffsetCount++
+	}
+	buffer = append(buffer, largeOffsets...)
+	buffer = append(buffer, packChecksum...)
+	if _, err := out.Write(buffer); err != nil {
+		return err
+	}
+	_, err := w.Write(h.Sum(nil))
+	return err
+}
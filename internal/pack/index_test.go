@@ -0,0 +1,245 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+type testObject struct {
+	objType int
+	body    []byte
+	// deltaOf is the position of the earlier object this one is stored as a
+	// delta against, or -1.
+	deltaOf int
+}
+
+func objectID(body []byte) []byte {
+	h := hash.New()
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// writeTestPack writes <dir>/<name>.pack and its .idx and opens them.
+func writeTestPack(t *testing.T, dir string, name string, objects []testObject) *Packfile {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer, uint32(len(objects)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for _, object := range objects {
+		offsets = append(offsets, writer.Offset())
+		sha1 := objectID(object.body)
+		if object.deltaOf >= 0 {
+			delta := DiffDelta(objects[object.deltaOf].body, object.body)
+			err = writer.WriteOfsDelta(sha1, offsets[object.deltaOf], delta)
+		} else {
+			err = writer.WriteObject(sha1, object.objType, int64(len(object.body)), bytes.NewReader(object.body))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksum, err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := filepath.Join(dir, name)
+	if err := os.WriteFile(base+".pack", buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, writer.Entries(), checksum); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(base+".idx", idx.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("line of text\n"), 200)
+	objects := []testObject{
+		{OBJ_BLOB, text, -1},
+		{OBJ_BLOB, append(append([]byte(nil), text...), "more\n"...), 0},
+		{OBJ_BLOB, append([]byte("head\n"), text...), 1},
+		{OBJ_TREE, []byte("not really a tree"), -1},
+		{OBJ_COMMIT, []byte("not really a commit"), -1},
+		{OBJ_BLOB, nil, -1},
+	}
+	p := writeTestPack(t, t.TempDir(), "pack-test", objects)
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if p.Index.Count() != len(objects) {
+		t.Fatalf("Count = %d, want %d", p.Index.Count(), len(objects))
+	}
+	for i, object := range objects {
+		objType, body, err := p.Read(objectID(object.body))
+		if err != nil {
+			t.Fatalf("object %d: %v", i, err)
+		}
+		if objType != object.objType || !bytes.Equal(body, object.body) {
+			t.Errorf("object %d: got %s of %d bytes, want %s of %d bytes", i, TypeName(objType), len(body), TypeName(object.objType), len(object.body))
+		}
+	}
+	if p.Has(objectID([]byte("missing"))) {
+		t.Errorf("Has reports an object that was never written")
+	}
+}
+
+func TestIndexLargeOffsets(t *testing.T) {
+	entries := []*Entry{
+		{Sha1: bytes.Repeat([]byte{0x30}, 20), Offset: 12, CRC32: 1},
+		{Sha1: bytes.Repeat([]byte{0x10}, 20), Offset: LARGE_OFFSET_FLAG - 1, CRC32: 2},
+		{Sha1: bytes.Repeat([]byte{0xff}, 20), Offset: LARGE_OFFSET_FLAG, CRC32: 3},
+		{Sha1: bytes.Repeat([]byte{0x00}, 20), Offset: 1 << 40, CRC32: 4},
+	}
+	var buffer bytes.Buffer
+	checksum := bytes.Repeat([]byte{0xab}, 20)
+	if err := WriteIndex(&buffer, entries, checksum); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ParseIndex(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(idx.PackChecksum, checksum) {
+		t.Errorf("PackChecksum = %x, want %x", idx.PackChecksum, checksum)
+	}
+	for _, entry := range entries {
+		i, ok := idx.FindIndex(entry.Sha1)
+		if !ok {
+			t.Fatalf("%x not found", entry.Sha1)
+		}
+		if idx.Offset(i) != entry.Offset || idx.CRC32(i) != entry.CRC32 {
+			t.Errorf("%x: offset %d crc %d, want %d %d", entry.Sha1, idx.Offset(i), idx.CRC32(i), entry.Offset, entry.CRC32)
+		}
+	}
+	for i := 1; i < idx.Count(); i++ {
+		if bytes.Compare(idx.Sha1(i-1), idx.Sha1(i)) >= 0 {
+			t.Errorf("names are not sorted at %d", i)
+		}
+	}
+	var found []string
+	idx.FindPrefix("1010", func(i int) { found = append(found, hex.EncodeToString(idx.Sha1(i))) })
+	if len(found) != 1 || found[0] != hex.EncodeToString(entries[1].Sha1) {
+		t.Errorf("FindPrefix(1010) = %v", found)
+	}
+}
+
+func TestParseIndexRejectsMalformedFiles(t *testing.T) {
+	var buffer bytes.Buffer
+	entries := []*Entry{{Sha1: bytes.Repeat([]byte{0x42}, 20), Offset: 12}}
+	if err := WriteIndex(&buffer, entries, make([]byte, 20)); err != nil {
+		t.Fatal(err)
+	}
+	valid := buffer.Bytes()
+	modified := func(fn func(data []byte) []byte) []byte {
+		return fn(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too small", valid[:100]},
+		{"bad signature", modified(func(data []byte) []byte { data[0] = 0; return data })},
+		{"version 1", modified(func(data []byte) []byte { data[7] = 1; return data })},
+		{"fanout not monotonic", modified(func(data []byte) []byte { data[8+3] = 5; return data })},
+		{"truncated", modified(func(data []byte) []byte {
+			data[8+255*4+3] = 2
+			return data
+		})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseIndex(test.data); err == nil {
+				t.Errorf("ParseIndex accepted a malformed index")
+			}
+		})
+	}
+}
+
+func TestMultiPackIndex(t *testing.T) {
+	dir := t.TempDir()
+	shared := []byte("in both packs")
+	first := writeTestPack(t, dir, "pack-a", []testObject{
+		{OBJ_BLOB, []byte("only in a"), -1},
+		{OBJ_BLOB, shared, -1},
+	})
+	second := writeTestPack(t, dir, "pack-b", []testObject{
+		{OBJ_BLOB, shared, -1},
+		{OBJ_BLOB, []byte("only in b"), -1},
+		{OBJ_TREE, []byte("tree in b"), -1},
+	})
+	var buffer bytes.Buffer
+	if err := WriteMultiPackIndex(&buffer, []*Packfile{second, first}); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(dir, "multi-pack-index")
+	if err := os.WriteFile(fileName, buffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	midx, err := ReadMultiPackIndex(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := midx.Verify(fileName); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if want := []string{"pack-a.idx", "pack-b.idx"}; len(midx.PackNames) != 2 || midx.PackNames[0] != want[0] || midx.PackNames[1] != want[1] {
+		t.Errorf("PackNames = %v, want %v", midx.PackNames, want)
+	}
+	if midx.Count() != 4 {
+		t.Errorf("Count = %d, want 4 distinct objects", midx.Count())
+	}
+	packs := []*Packfile{first, second}
+	tests := []struct {
+		body  []byte
+		packs []int
+	}{
+		{[]byte("only in a"), []int{0}},
+		{[]byte("only in b"), []int{1}},
+		{[]byte("tree in b"), []int{1}},
+		{shared, []int{0, 1}},
+	}
+	for _, test := range tests {
+		sha1 := objectID(test.body)
+		packID, offset, ok := midx.Find(sha1)
+		if !ok {
+			t.Errorf("%q not found", test.body)
+			continue
+		}
+		if !slices.Contains(test.packs, packID) {
+			t.Errorf("%q: pack %d, want one of %v", test.body, packID, test.packs)
+			continue
+		}
+		if _, body, err := packs[packID].ReadAt(offset); err != nil || !bytes.Equal(body, test.body) {
+			t.Errorf("%q: read %q, %v at offset %d", test.body, body, err, offset)
+		}
+	}
+	if _, _, ok := midx.Find(objectID([]byte("missing"))); ok {
+		t.Errorf("Find reports an object that was never written")
+	}
+	corrupt := append([]byte(nil), buffer.Bytes()...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if err := os.WriteFile(fileName, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := midx.Verify(fileName); err == nil {
+		t.Errorf("Verify accepted a bad checksum")
+	}
+}
@@ -0,0 +1,289 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// MIDX_FILE_NAME is the multi-pack-index in a pack directory. It maps every
+// object of the packs it lists to a pack and an offset, so a lookup is one
+// binary search however many packs there are.
+const MIDX_FILE_NAME = "multi-pack-index"
+
+var MIDX_SIGNATURE = []byte("MIDX")
+
+const MIDX_VERSION = 1
+
+// Chunk IDs of the multi-pack-index.
+const (
+	MIDX_CHUNK_PACK_NAMES    = 0x504e414d // "PNAM"
+	MIDX_CHUNK_OID_FANOUT    = 0x4f494446 // "OIDF"
+	MIDX_CHUNK_OID_LOOKUP    = 0x4f49444c // "OIDL"
+	MIDX_CHUNK_OBJECT_OFFSET = 0x4f4f4646 // "OOFF"
+	MIDX_CHUNK_LARGE_OFFSET  = 0x4c4f4646 // "LOFF"
+)
+
+const MIDX_HEADER_SIZE = 12
+const MIDX_CHUNK_LOOKUP_WIDTH = 12
+
+type MultiPackIndex struct {
+	// PackNames are the .idx file names of the packs, sorted. Objects refer
+	// to packs by position in this list.
+	PackNames    []string
+	fanout       [256]uint32
+	names        []byte
+	offsets      []byte
+	largeOffsets []byte
+	hashSize     int
+	Checksum     []byte
+}
+
+type midxObject struct {
+	sha1   []byte
+	pack   uint32
+	offset int64
+	mtime  int64
+}
+
+// WriteMultiPackIndex writes a multi-pack-index covering packs. An object
+// found in several packs is taken from the most recently modified one.
+func WriteMultiPackIndex(w io.Writer, packs []*Packfile) error {
+	sortedPacks := append([]*Packfile(nil), packs...)
+	sort.Slice(sortedPacks, func(i, j int) bool {
+		return filepath.Base(sortedPacks[i].Name) < filepath.Base(sortedPacks[j].Name)
+	})
+	var objectList []*midxObject
+	var packNames []byte
+	for packID, p := range sortedPacks {
+		stat, err := os.Stat(p.Name + ".pack")
+		if err != nil {
+			return err
+		}
+		packNames = append(packNames, filepath.Base(p.Name)+".idx"...)
+		packNames = append(packNames, 0)
+		for i := 0; i < p.Index.Count(); i++ {
+			objectList = append(objectList, &midxObject{
+				sha1:   p.Index.Sha1(i),
+				pack:   uint32(packID),
+				offset: p.Index.Offset(i),
+				mtime:  stat.ModTime().UnixNano(),
+			})
+		}
+	}
+	for len(packNames)%4 != 0 {
+		packNames = append(packNames, 0)
+	}
+	sort.SliceStable(objectList, func(i, j int) bool {
+		if c := bytes.Compare(objectList[i].sha1, objectList[j].sha1); c != 0 {
+			return c < 0
+		}
+		return objectList[i].mtime > objectList[j].mtime
+	})
+	var unique []*midxObject
+	for _, object := range objectList {
+		if len(unique) > 0 && bytes.Equal(unique[len(unique)-1].sha1, object.sha1) {
+			continue
+		}
+		unique = append(unique, object)
+	}
+
+	var fanout, names, offsets, largeOffsets []byte
+	var counts [256]uint32
+	for _, object := range unique {
+		counts[object.sha1[0]]++
+		names = append(names, object.sha1...)
+		offsets = binary.BigEndian.AppendUint32(offsets, object.pack)
+		if object.offset < LARGE_OFFSET_FLAG {
+			offsets = binary.BigEndian.AppendUint32(offsets, uint32(object.offset))
+			continue
+		}
+		offsets = binary.BigEndian.AppendUint32(offsets, LARGE_OFFSET_FLAG|uint32(len(largeOffsets)/8))
+		largeOffsets = binary.BigEndian.AppendUint64(largeOffsets, uint64(object.offset))
+	}
+	total := uint32(0)
+	for _, count := range counts {
+		total += count
+		fanout = binary.BigEndian.AppendUint32(fanout, total)
+	}
+	chunks := []struct {
+		id   uint32
+		data []byte
+	}{
+		{MIDX_CHUNK_PACK_NAMES, packNames},
+		{MIDX_CHUNK_OID_FANOUT, fanout},
+		{MIDX_CHUNK_OID_LOOKUP, names},
+		{MIDX_CHUNK_OBJECT_OFFSET, offsets},
+	}
+	if len(largeOffsets) > 0 {
+		chunks = append(chunks, struct {
+			id   uint32
+			data []byte
+		}{MIDX_CHUNK_LARGE_OFFSET, largeOffsets})
+	}
+
+	algorithm, err := hash.Current()
+	if err != nil {
+		return err
+	}
+	h := algorithm.New()
+	out := io.MultiWriter(w, h)
+	buffer := append([]byte(nil), MIDX_SIGNATURE...)
+	buffer = append(buffer, MIDX_VERSION, algorithm.FormatID, byte(len(chunks)), 0)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(sortedPacks)))
+	offset := uint64(MIDX_HEADER_SIZE + (len(chunks)+1)*MIDX_CHUNK_LOOKUP_WIDTH)
+	for _, chunk := range chunks {
+		buffer = binary.BigEndian.AppendUint32(buffer, chunk.id)
+		buffer = binary.BigEndian.AppendUint64(buffer, offset)
+		offset += uint64(len(chunk.data))
+	}
+	buffer = binary.BigEndian.AppendUint32(buffer, 0)
+	buffer = binary.BigEndian.AppendUint64(buffer, offset)
+	for _, chunk := range chunks {
+		buffer = append(buffer, chunk.data...)
+	}
+	if _, err := out.Write(buffer); err != nil {
+		return err
+	}
+	_, err = w.Write(h.Sum(nil))
+	return err
+}
+
+func ReadMultiPackIndex(fileName string) (*MultiPackIndex, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	midx, err := ParseMultiPackIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return midx, nil
+}
+
+func ParseMultiPackIndex(data []byte) (*MultiPackIndex, error) {
+	hashSize := hash.Size()
+	if len(data) < MIDX_HEADER_SIZE+MIDX_CHUNK_LOOKUP_WIDTH+hashSize {
+		return nil, errors.New("multi-pack-index too small")
+	}
+	if !bytes.Equal(data[:4], MIDX_SIGNATURE) {
+		return nil, errors.New("bad multi-pack-index signature")
+	}
+	if data[4] != MIDX_VERSION {
+		return nil, fmt.Errorf("unsupported multi-pack-index version %d", data[4])
+	}
+	algorithm, err := hash.Current()
+	if err != nil {
+		return nil, err
+	}
+	if data[5] != algorithm.FormatID {
+		return nil, errors.New("multi-pack-index hash version does not match the repository")
+	}
+	chunkCount := int(data[6])
+	if data[7] != 0 {
+		return nil, errors.New("incremental multi-pack-index is not supported")
+	}
+	packCount := int(binary.BigEndian.Uint32(data[8:12]))
+	if len(data) < MIDX_HEADER_SIZE+(chunkCount+1)*MIDX_CHUNK_LOOKUP_WIDTH+hashSize {
+		return nil, errors.New("multi-pack-index truncated")
+	}
+	end := uint64(len(data) - hashSize)
+	chunks := map[uint32][]byte{}
+	for i := 0; i < chunkCount; i++ {
+		entry := data[MIDX_HEADER_SIZE+i*MIDX_CHUNK_LOOKUP_WIDTH:]
+		next := data[MIDX_HEADER_SIZE+(i+1)*MIDX_CHUNK_LOOKUP_WIDTH:]
+		start := binary.BigEndian.Uint64(entry[4:12])
+		stop := binary.BigEndian.Uint64(next[4:12])
+		if start > stop || stop > end {
+			return nil, errors.New("multi-pack-index chunk out of bounds")
+		}
+		chunks[binary.BigEndian.Uint32(entry[0:4])] = data[start:stop]
+	}
+	midx := &MultiPackIndex{hashSize: hashSize, Checksum: data[end:]}
+	for _, name := range bytes.Split(chunks[MIDX_CHUNK_PACK_NAMES], []byte{0}) {
+		if len(name) > 0 {
+			midx.PackNames = append(midx.PackNames, string(name))
+		}
+	}
+	if len(midx.PackNames) != packCount {
+		return nil, errors.New("multi-pack-index pack names do not match the pack count")
+	}
+	fanout := chunks[MIDX_CHUNK_OID_FANOUT]
+	if len(fanout) != 256*4 {
+		return nil, errors.New("multi-pack-index fanout is missing")
+	}
+	for i := range midx.fanout {
+		midx.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && midx.fanout[i] < midx.fanout[i-1] {
+			return nil, errors.New("multi-pack-index fanout is not monotonic")
+		}
+	}
+	count := int(midx.fanout[255])
+	midx.names = chunks[MIDX_CHUNK_OID_LOOKUP]
+	midx.offsets = chunks[MIDX_CHUNK_OBJECT_OFFSET]
+	midx.largeOffsets = chunks[MIDX_CHUNK_LARGE_OFFSET]
+	if len(midx.names) != count*hashSize || len(midx.offsets) != count*8 {
+		return nil, errors.New("multi-pack-index object chunks are truncated")
+	}
+	return midx, nil
+}
+
+// Verify checks the trailing checksum of the file midx was read from.
+func (midx *MultiPackIndex) Verify(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data[:len(data)-midx.hashSize])
+	if !bytes.Equal(h.Sum(nil), data[len(data)-midx.hashSize:]) {
+		return errors.New("multi-pack-index checksum mismatch")
+	}
+	return nil
+}
+
+func (midx *MultiPackIndex) Count() int {
+	return int(midx.fanout[255])
+}
+
+func (midx *MultiPackIndex) Sha1(i int) []byte {
+	return midx.names[i*midx.hashSize : (i+1)*midx.hashSize]
+}
+
+// Object returns the pack position and offset of the i-th object.
+func (midx *MultiPackIndex) Object(i int) (int, int64) {
+	packID := int(binary.BigEndian.Uint32(midx.offsets[i*8:]))
+	offset := binary.BigEndian.Uint32(midx.offsets[i*8+4:])
+	if offset&LARGE_OFFSET_FLAG == 0 {
+		return packID, int64(offset)
+	}
+	large := int(offset&^LARGE_OFFSET_FLAG) * 8
+	if large+8 > len(midx.largeOffsets) {
+		return packID, -1
+	}
+	return packID, int64(binary.BigEndian.Uint64(midx.largeOffsets[large:]))
+}
+
+// Find returns the pack position and offset of sha1.
+func (midx *MultiPackIndex) Find(sha1 []byte) (int, int64, bool) {
+	low := 0
+	if sha1[0] > 0 {
+		low = int(midx.fanout[sha1[0]-1])
+	}
+	high := int(midx.fanout[sha1[0]])
+	i := low + sort.Search(high-low, func(i int) bool {
+		return bytes.Compare(midx.Sha1(low+i), sha1) >= 0
+	})
+	if i < high && bytes.Equal(midx.Sha1(i), sha1) {
+		packID, offset := midx.Object(i)
+		return packID, offset, true
+	}
+	return 0, 0, false
+}
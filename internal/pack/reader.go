@@ -0,0 +1,318 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+// MAX_DELTA_CHAIN guards against cycles in corrupt packs.
+var MAX_DELTA_CHAIN = 10000
+
+// ExternalBaseFunc resolves REF_DELTA bases that are not in the pack itself.
+type ExternalBaseFunc func(sha1 []byte) (int, []byte, error)
+
+// Packfile reads objects from a .pack using its .idx.
+type Packfile struct {
+	Name  string
+	Index *Index
+	file  *os.File
+	size  int64
+	cache *deltaBaseCache
+	// ExternalBase is consulted for REF_DELTA bases missing from this pack.
+	ExternalBase ExternalBaseFunc
+}
+
+// entryHeader describes one entry as stored in the pack.
+type entryHeader struct {
+	objType    int
+	size       int64
+	dataOffset int64
+	baseOffset int64
+	baseSha1   []byte
+}
+
+// Open opens <name>.pack and <name>.idx, where name has no extension.
+func Open(name string) (*Packfile, error) {
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".idx"), ".pack")
+	idx, err := ReadIndexFile(name + ".idx")
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name + ".pack")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	p := &Packfile{Name: name, Index: idx, file: file, size: stat.Size(), cache: newDeltaBaseCache(DELTA_BASE_CACHE_LIMIT)}
+	if err := p.verifyHeader(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s.pack: %w", name, err)
+	}
+	return p, nil
+}
+
+func (p *Packfile) verifyHeader() error {
+	header := make([]byte, 12)
+	if _, err := p.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:4]) != PACK_SIGNATURE {
+		return errors.New("bad pack signature")
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != PACK_VERSION && version != 3 {
+		return fmt.Errorf("unsupported pack version %d", version)
+	}
+	if count := binary.BigEndian.Uint32(header[8:12]); int(count) != p.Index.Count() {
+		return fmt.Errorf("pack has %d objects but its index has %d", count, p.Index.Count())
+	}
+	trailer := make([]byte, hash.Size())
+	if _, err := p.file.ReadAt(trailer, p.size-int64(hash.Size())); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer, p.Index.PackChecksum) {
+		return errors.New("pack checksum does not match its index")
+	}
+	return nil
+}
+
+func (p *Packfile) Close() error {
+	return p.file.Close()
+}
+
+func (p *Packfile) Size() int64 {
+	return p.size
+}
+
+func (p *Packfile) Has(sha1 []byte) bool {
+	_, ok := p.Index.FindIndex(sha1)
+	return ok
+}
+
+func (p *Packfile) readEntryHeader(offset int64) (*entryHeader, error) {
+	if offset < 12 || offset >= p.size-int64(hash.Size()) {
+		return nil, fmt.Errorf("bad object offset %d", offset)
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(p.file, offset, p.size-offset), 64)
+	b, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	header := &entryHeader{objType: int(b>>4) & 7, size: int64(b & 0x0f)}
+	consumed := int64(1)
+	shift := uint(4)
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return nil, err
+		}
+		consumed++
+		header.size |= int64(b&0x7f) << shift
+		shift += 7
+		if shift > 63 {
+			return nil, errors.New("object size overflows")
+		}
+	}
+	switch header.objType {
+	case OBJ_OFS_DELTA:
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		consumed++
+		distance := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, err = reader.ReadByte(); err != nil {
+				return nil, err
+			}
+			consumed++
+			distance = ((distance + 1) << 7) | int64(b&0x7f)
+		}
+		if distance <= 0 || distance > offset {
+			return nil, fmt.Errorf("bad delta base offset at %d", offset)
+		}
+		header.baseOffset = offset - distance
+	case OBJ_REF_DELTA:
+		header.baseSha1 = make([]byte, hash.Size())
+		if _, err := io.ReadFull(reader, header.baseSha1); err != nil {
+			return nil, err
+		}
+		consumed += int64(hash.Size())
+	case OBJ_COMMIT, OBJ_TREE, OBJ_BLOB, OBJ_TAG:
+	default:
+		return nil, fmt.Errorf("unknown object type %d at offset %d", header.objType, offset)
+	}
+	header.dataOffset = offset + consumed
+	return header, nil
+}
+
+// inflate reads the zlib stream of an entry; size is its inflated size.
+func (p *Packfile) inflate(header *entryHeader) ([]byte, error) {
+	reader, err := p.openData(header)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data := make([]byte, header.size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (p *Packfile) openData(header *entryHeader) (io.ReadCloser, error) {
+	section := io.NewSectionReader(p.file, header.dataOffset, p.size-header.dataOffset)
+	return utils.NewDecompressReader(bufio.NewReader(section))
+}
+
+// ReadAt returns the type and body of the object stored at offset,
+// resolving any delta chain it is part of.
+func (p *Packfile) ReadAt(offset int64) (int, []byte, error) {
+	if objType, data, ok := p.cache.get(offset); ok {
+		// キャッシュの中身を呼び出し元に書き換えられないようにコピーを返す
+		return objType, append([]byte(nil), data...), nil
+	}
+	// デルタを根元まで辿り、ベースから順に適用する
+	var chain []*entryHeader
+	var chainOffsets []int64
+	objType := 0
+	var data []byte
+	current := offset
+	for {
+		if cachedType, cachedData, ok := p.cache.get(current); ok {
+			objType, data = cachedType, cachedData
+			break
+		}
+		header, err := p.readEntryHeader(current)
+		if err != nil {
+			return 0, nil, err
+		}
+		if header.objType != OBJ_OFS_DELTA && header.objType != OBJ_REF_DELTA {
+			if data, err = p.inflate(header); err != nil {
+				return 0, nil, err
+			}
+			objType = header.objType
+			if len(chain) > 0 {
+				p.cache.add(current, objType, data)
+			}
+			break
+		}
+		chain = append(chain, header)
+		chainOffsets = append(chainOffsets, current)
+		if len(chain) > MAX_DELTA_CHAIN {
+			return 0, nil, errors.New("delta chain too long")
+		}
+		if header.objType == OBJ_OFS_DELTA {
+			current = header.baseOffset
+			continue
+		}
+		if baseOffset, ok := p.Index.Find(header.baseSha1); ok {
+			current = baseOffset
+			continue
+		}
+		if p.ExternalBase == nil {
+			return 0, nil, fmt.Errorf("delta base %x not found", header.baseSha1)
+		}
+		objType, data, err = p.ExternalBase(header.baseSha1)
+		if err != nil {
+			return 0, nil, fmt.Errorf("delta base %x: %w", header.baseSha1, err)
+		}
+		break
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		delta, err := p.inflate(chain[i])
+		if err != nil {
+			return 0, nil, err
+		}
+		if data, err = ApplyDelta(data, delta); err != nil {
+			return 0, nil, fmt.Errorf("object at offset %d: %w", chainOffsets[i], err)
+		}
+		// 中間のベースは他のデルタからも参照されやすいのでキャッシュする
+		if i > 0 {
+			p.cache.add(chainOffsets[i], objType, data)
+		}
+	}
+	return objType, data, nil
+}
+
+// Read returns the type and body of sha1.
+func (p *Packfile) Read(sha1 []byte) (int, []byte, error) {
+	offset, ok := p.Index.Find(sha1)
+	if !ok {
+		return 0, nil, fmt.Errorf("%x not found in pack", sha1)
+	}
+	return p.ReadAt(offset)
+}
+
+// Open returns the type, size and a reader over the body of sha1. Objects
+// stored whole are inflated as they are read; deltas are resolved in memory.
+func (p *Packfile) Open(sha1 []byte) (int, int64, io.ReadCloser, error) {
+	offset, ok := p.Index.Find(sha1)
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("%x not found in pack", sha1)
+	}
+	return p.OpenAt(offset)
+}
+
+// OpenAt is Open for the entry at offset.
+func (p *Packfile) OpenAt(offset int64) (int, int64, io.ReadCloser, error) {
+	header, err := p.readEntryHeader(offset)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if header.objType != OBJ_OFS_DELTA && header.objType != OBJ_REF_DELTA {
+		reader, err := p.openData(header)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		return header.objType, header.size, reader, nil
+	}
+	objType, data, err := p.ReadAt(offset)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return objType, int64(len(data)), io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Verify re-reads the whole pack, checking its trailing checksum and the
+// CRC32 the index records for every entry.
+func (p *Packfile) Verify() error {
+	h := hash.New()
+	if _, err := io.Copy(h, io.NewSectionReader(p.file, 0, p.size-int64(hash.Size()))); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), p.Index.PackChecksum) {
+		return errors.New("pack checksum mismatch")
+	}
+	order := p.Index.PackOrder()
+	for n, i := range order {
+		start := p.Index.Offset(i)
+		end := p.size - int64(hash.Size())
+		if n+1 < len(order) {
+			end = p.Index.Offset(order[n+1])
+		}
+		if start < 12 || end <= start {
+			return fmt.Errorf("bad offset for %x", p.Index.Sha1(i))
+		}
+		crc := crc32.NewIEEE()
+		if _, err := io.Copy(crc, io.NewSectionReader(p.file, start, end-start)); err != nil {
+			return err
+		}
+		if crc.Sum32() != p.Index.CRC32(i) {
+			return fmt.Errorf("CRC mismatch for %x", p.Index.Sha1(i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package pack
+
+import "fmt"
+
+// Object type codes used in pack entry headers.
+const (
+	OBJ_COMMIT    = 1
+	OBJ_TREE      = 2
+	OBJ_BLOB      = 3
+	OBJ_TAG       = 4
+	OBJ_OFS_DELTA = 6
+	OBJ_REF_DELTA = 7
+)
+
+var typeNames = map[int]string{
+	OBJ_COMMIT: "commit",
+	OBJ_TREE:   "tree",
+	OBJ_BLOB:   "blob",
+	OBJ_TAG:    "tag",
+}
+
+func TypeName(objType int) string {
+	if name, ok := typeNames[objType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", objType)
+}
+
+func TypeFromName(name string) (int, error) {
+	for objType, typeName := range typeNames {
+		if typeName == name {
+			return objType, nil
+		}
+	}
+	return 0, fmt.Errorf("object type %q cannot be packed", name)
+}
@@ -0,0 +1,137 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	stdhash "hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/marutaku/go-git/internal/hash"
+	"github.com/marutaku/go-git/internal/utils"
+)
+
+const PACK_SIGNATURE = "PACK"
+const PACK_VERSION = 2
+
+// Entry records where an object was written in a pack.
+type Entry struct {
+	Sha1   []byte
+	Offset int64
+	CRC32  uint32
+}
+
+// Writer writes a version 2 packfile. The number of objects has to be known
+// up front because it is part of the header.
+type Writer struct {
+	w       io.Writer
+	hash    stdhash.Hash
+	offset  int64
+	count   uint32
+	entries []*Entry
+}
+
+func NewWriter(w io.Writer, count uint32) (*Writer, error) {
+	pw := &Writer{w: w, hash: hash.New(), count: count}
+	header := []byte(PACK_SIGNATURE)
+	header = binary.BigEndian.AppendUint32(header, PACK_VERSION)
+	header = binary.BigEndian.AppendUint32(header, count)
+	if _, err := pw.Write(header); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write writes raw bytes to the pack, keeping the offset and checksum up to date.
+func (pw *Writer) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.hash.Write(p[:n])
+	pw.offset += int64(n)
+	return n, err
+}
+
+// AppendEntryHeader encodes the type and inflated size of a pack entry.
+func AppendEntryHeader(buffer []byte, objType int, size int64) []byte {
+	c := byte(objType<<4) | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		buffer = append(buffer, c|0x80)
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	return append(buffer, c)
+}
+
+// WriteObject stores an object whose body of size bytes is read from r.
+func (pw *Writer) WriteObject(sha1 []byte, objType int, size int64, r io.Reader) error {
+	return pw.writeEntry(sha1, AppendEntryHeader(nil, objType, size), size, r)
+}
+
+// WriteOfsDelta stores an object as a delta against the object written at baseOffset.
+func (pw *Writer) WriteOfsDelta(sha1 []byte, baseOffset int64, delta []byte) error {
+	if baseOffset < 12 || baseOffset >= pw.offset {
+		return fmt.Errorf("%x: delta base at offset %d has not been written", sha1, baseOffset)
+	}
+	header := AppendEntryHeader(nil, OBJ_OFS_DELTA, int64(len(delta)))
+	header = append(header, encodeBaseDistance(pw.offset-baseOffset)...)
+	return pw.writeEntry(sha1, header, int64(len(delta)), bytes.NewReader(delta))
+}
+
+// encodeBaseDistance encodes the distance back to an OFS_DELTA base. Every
+// continuation byte also adds one, so each length has its own value range.
+func encodeBaseDistance(distance int64) []byte {
+	buffer := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		buffer = append([]byte{0x80 | byte(distance&0x7f)}, buffer...)
+	}
+	return buffer
+}
+
+// Offset returns where the next object will be written.
+func (pw *Writer) Offset() int64 {
+	return pw.offset
+}
+
+func (pw *Writer) writeEntry(sha1 []byte, header []byte, size int64, r io.Reader) error {
+	if uint32(len(pw.entries)) == pw.count {
+		return fmt.Errorf("pack already holds the %d objects it was created for", pw.count)
+	}
+	entry := &Entry{Sha1: sha1, Offset: pw.offset}
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(pw, crc)
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	compressor := utils.NewCompressWriter(out)
+	written, err := io.Copy(compressor, r)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("%x: expected %d bytes, got %d", sha1, size, written)
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	entry.CRC32 = crc.Sum32()
+	pw.entries = append(pw.entries, entry)
+	return nil
+}
+
+// Close writes the trailing checksum and returns it.
+func (pw *Writer) Close() ([]byte, error) {
+	if uint32(len(pw.entries)) != pw.count {
+		return nil, fmt.Errorf("pack header promised %d objects, %d written", pw.count, len(pw.entries))
+	}
+	checksum := pw.hash.Sum(nil)
+	if _, err := pw.w.Write(checksum); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+func (pw *Writer) Entries() []*Entry {
+	return pw.entries
+}
@@ -0,0 +1,271 @@
+package packer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/pack"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var DEFAULT_WINDOW = 10
+var DEFAULT_DEPTH = 50
+
+// Objects larger than BIG_FILE_THRESHOLD are never delta compressed, so they
+// can be streamed into the pack instead of being held in memory.
+var BIG_FILE_THRESHOLD int64 = 512 * 1024 * 1024
+
+// Objects smaller than MIN_DELTA_SIZE are not worth a delta.
+var MIN_DELTA_SIZE int64 = 50
+
+type Options struct {
+	// Window is how many preceding objects are tried as delta bases.
+	// A window of 0 disables delta compression.
+	Window int
+	// Depth is the longest delta chain allowed.
+	Depth int
+}
+
+// DefaultOptions reads pack.window and pack.depth from the repository config.
+func DefaultOptions() (*Options, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return nil, err
+	}
+	window, err := repositoryConfig.GetInt("pack.window", DEFAULT_WINDOW)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := repositoryConfig.GetInt("pack.depth", DEFAULT_DEPTH)
+	if err != nil {
+		return nil, err
+	}
+	return &Options{Window: window, Depth: depth}, nil
+}
+
+type packObject struct {
+	sha1     []byte
+	objType  string
+	size     int64
+	nameHash uint32
+	base     *packObject
+	delta    []byte
+	depth    int
+	offset   int64
+	written  bool
+}
+
+// NameHash is Git's pack name hash. Later characters weigh the most, so
+// files with the same basename or extension sort next to each other.
+func NameHash(name string) uint32 {
+	h := uint32(0)
+	for _, c := range []byte(name) {
+		if unicode.IsSpace(rune(c)) {
+			continue
+		}
+		h = (h >> 2) + (uint32(c) << 24)
+	}
+	return h
+}
+
+// WritePack packs the given objects into <baseName>-<checksum>.pack and a
+// matching .idx, and returns the pack checksum. Objects listed more than once
+// are only stored once. Paths on the objects guide delta base selection.
+func WritePack(baseName string, objectList []*walk.Object, options *Options) ([]byte, error) {
+	dir := filepath.Dir(baseName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var packObjects []*packObject
+	for _, object := range objectList {
+		if seen[string(object.Sha1)] {
+			continue
+		}
+		seen[string(object.Sha1)] = true
+		objType, size, err := objects.ReadSha1Header(object.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		packObjects = append(packObjects, &packObject{
+			sha1:     object.Sha1,
+			objType:  objType,
+			size:     size,
+			nameHash: NameHash(object.Path),
+		})
+	}
+	if options.Window > 0 && options.Depth > 0 {
+		if err := findDeltas(packObjects, options); err != nil {
+			return nil, err
+		}
+	}
+
+	packFile, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(packFile.Name())
+	defer packFile.Close()
+	buffered := bufio.NewWriter(packFile)
+	writer, err := pack.NewWriter(buffered, uint32(len(packObjects)))
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range packObjects {
+		if err := writeObject(writer, object); err != nil {
+			return nil, err
+		}
+	}
+	checksum, err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	if err := durable.SyncFile(packFile, config.FSYNC_PACK); err != nil {
+		return nil, err
+	}
+	if err := packFile.Close(); err != nil {
+		return nil, err
+	}
+
+	idxFile, err := os.CreateTemp(dir, "tmp_idx_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(idxFile.Name())
+	defer idxFile.Close()
+	buffered = bufio.NewWriter(idxFile)
+	if err := pack.WriteIndex(buffered, writer.Entries(), checksum); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+	if err := durable.SyncFile(idxFile, config.FSYNC_PACK); err != nil {
+		return nil, err
+	}
+	if err := idxFile.Close(); err != nil {
+		return nil, err
+	}
+
+	// .idxが見えた時点で.packが揃っているように、.packを先にリネームする
+	name := fmt.Sprintf("%s-%x", baseName, checksum)
+	if err := os.Rename(packFile.Name(), name+".pack"); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(idxFile.Name(), name+".idx"); err != nil {
+		return nil, err
+	}
+	if err := durable.SyncDir(dir, config.FSYNC_PACK); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// writeObject writes an object, writing its delta base first when needed
+// because OFS_DELTA can only point backwards.
+func writeObject(writer *pack.Writer, object *packObject) error {
+	if object.written {
+		return nil
+	}
+	if object.base != nil {
+		if err := writeObject(writer, object.base); err != nil {
+			return err
+		}
+		object.offset = writer.Offset()
+		object.written = true
+		err := writer.WriteOfsDelta(object.sha1, object.base.offset, object.delta)
+		object.delta = nil
+		return err
+	}
+	object.offset = writer.Offset()
+	object.written = true
+	reader, err := objects.OpenSha1File(object.sha1)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	objType, err := pack.TypeFromName(reader.Type)
+	if err != nil {
+		return fmt.Errorf("%x: %w", object.sha1, err)
+	}
+	return writer.WriteObject(object.sha1, objType, reader.Size, reader)
+}
+
+type windowEntry struct {
+	object *packObject
+	body   []byte
+	index  *pack.DeltaIndex
+}
+
+// findDeltas sorts candidates by type, name hash and decreasing size, then
+// tries each object against the previous Window objects as delta bases.
+func findDeltas(packObjects []*packObject, options *Options) error {
+	var candidates []*packObject
+	for _, object := range packObjects {
+		if object.size >= MIN_DELTA_SIZE && object.size <= BIG_FILE_THRESHOLD {
+			candidates = append(candidates, object)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.objType != b.objType {
+			return a.objType < b.objType
+		}
+		if a.nameHash != b.nameHash {
+			return a.nameHash < b.nameHash
+		}
+		return a.size > b.size
+	})
+	var window []*windowEntry
+	for _, object := range candidates {
+		_, body, err := objects.ReadSha1File(object.sha1)
+		if err != nil {
+			return err
+		}
+		for i := len(window) - 1; i >= 0; i-- {
+			base := window[i]
+			if base.object.objType != object.objType {
+				break
+			}
+			if base.object.depth >= options.Depth {
+				continue
+			}
+			maxSize := len(body)/2 - 20
+			if object.delta != nil {
+				maxSize = len(object.delta) - 1
+			}
+			sizeDifference := len(body) - len(base.body)
+			if sizeDifference < 0 {
+				sizeDifference = -sizeDifference
+			}
+			if maxSize <= 0 || sizeDifference >= maxSize {
+				continue
+			}
+			if base.index == nil {
+				base.index = pack.NewDeltaIndex(base.body)
+			}
+			delta := base.index.Diff(body, maxSize)
+			if delta == nil {
+				continue
+			}
+			object.base = base.object
+			object.delta = delta
+			object.depth = base.object.depth + 1
+		}
+		window = append(window, &windowEntry{object: object, body: body})
+		if len(window) > options.Window {
+			window = window[1:]
+		}
+	}
+	return nil
+}
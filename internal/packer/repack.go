@@ -0,0 +1,262 @@
+package packer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/marutaku/go-git/internal/bitmap"
+	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/hash"
+	gitobject "github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/pack"
+	"github.com/marutaku/go-git/internal/refs"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+type RepackOptions struct {
+	// All packs every object, including the ones already in packs, into a
+	// single new pack. Otherwise only loose objects are packed.
+	All bool
+	// Delete removes what became redundant: the old packs when All is set
+	// and the loose objects that were packed.
+	Delete bool
+	// Reachable, when set, limits the new pack to these objects. Unreachable
+	// objects from deleted packs are written back as loose objects so that
+	// prune can expire them later.
+	Reachable map[string]bool
+	// WriteBitmap stores reachability bitmaps for the ref tips next to the
+	// new pack. It needs All: a bitmap must cover full closures.
+	WriteBitmap bool
+	Pack        *Options
+}
+
+// DefaultWriteBitmap reads repack.writebitmaps.
+func DefaultWriteBitmap() (bool, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return false, err
+	}
+	return repositoryConfig.GetBool("repack.writebitmaps", false)
+}
+
+var typeOrder = map[string]int{"commit": 0, "tag": 1, "tree": 2, "blob": 3}
+
+// Repack writes a new pack into the repository and returns its checksum,
+// or nil when there was nothing to pack.
+func Repack(options *RepackOptions) ([]byte, error) {
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		return nil, errors.New("repack needs a repository object store")
+	}
+	if err := store.Packs.Reload(); err != nil {
+		return nil, err
+	}
+	oldPacks := store.Packs.Packs()
+
+	var sha1s [][]byte
+	collect := func(sha1 []byte) error {
+		sha1s = append(sha1s, sha1)
+		return nil
+	}
+	var err error
+	if options.All {
+		err = store.Iterate(collect)
+	} else {
+		err = store.Loose.Iterate(collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var unreachable [][]byte
+	if options.WriteBitmap && !options.All {
+		return nil, errors.New("bitmaps can only be written when repacking everything")
+	}
+	if options.Reachable != nil {
+		sha1s, unreachable = partition(store, sha1s, options.Reachable)
+	}
+	if len(sha1s) == 0 {
+		return nil, nil
+	}
+	objectList, err := nameObjects(sha1s)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := WritePack(fmt.Sprintf("%s/pack", objects.GetPackDirectory()), objectList, options.Pack)
+	if err != nil {
+		return nil, err
+	}
+	newPackName := fmt.Sprintf("%s/pack-%x", objects.GetPackDirectory(), checksum)
+	if options.WriteBitmap {
+		if err := writeBitmap(store, newPackName, objectList); err != nil {
+			return nil, err
+		}
+	}
+	if options.Delete {
+		if err := deleteRedundant(store, options, oldPacks, newPackName, objectList, unreachable); err != nil {
+			return nil, err
+		}
+	}
+	// 既にmulti-pack-indexを使っているなら新しいパックも含めて書き直す
+	if store.Packs.HasMultiPackIndex() {
+		if err := store.Packs.WriteMultiPackIndex(); err != nil {
+			return nil, err
+		}
+	}
+	return checksum, nil
+}
+
+// writeBitmap stores bitmaps for the ref tips in the new pack.
+func writeBitmap(store *objects.RepositoryStore, name string, objectList []*walk.Object) error {
+	if err := store.Packs.Reload(); err != nil {
+		return err
+	}
+	var newPack *pack.Packfile
+	for _, p := range store.Packs.Packs() {
+		if p.Name == name {
+			newPack = p
+		}
+	}
+	if newPack == nil {
+		return fmt.Errorf("%s: new pack not found", name)
+	}
+	var tips [][]byte
+	err := refs.ForEachRef(func(name string, sha1 []byte) error {
+		tips = append(tips, sha1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	types := make(map[string]string, len(objectList))
+	for _, object := range objectList {
+		types[string(object.Sha1)] = object.Type
+	}
+	_, err = bitmap.Write(newPack, types, tips)
+	return err
+}
+
+func deleteRedundant(store *objects.RepositoryStore, options *RepackOptions, oldPacks []*pack.Packfile, newPackName string, objectList []*walk.Object, unreachable [][]byte) error {
+	if options.All {
+		if err := explode(store, unreachable); err != nil {
+			return err
+		}
+		for _, p := range oldPacks {
+			// 同じ内容なら同じ名前のパックができるので、それは消さない
+			if p.Name == newPackName {
+				continue
+			}
+			if err := store.Packs.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	for _, object := range objectList {
+		if !store.Loose.Has(object.Sha1) {
+			continue
+		}
+		if err := store.Loose.Delete(object.Sha1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partition splits objects into those to pack and the unreachable ones that
+// only live in packs. Loose unreachable objects are left alone for prune.
+// In the original repository format packed objects cannot be turned back
+// into loose ones, so unreachable packed objects stay packed there.
+func partition(store *objects.RepositoryStore, sha1s [][]byte, reachable map[string]bool) ([][]byte, [][]byte) {
+	var kept, unreachable [][]byte
+	for _, sha1 := range sha1s {
+		switch {
+		case reachable[string(sha1)]:
+			kept = append(kept, sha1)
+		case store.Loose.Has(sha1):
+		case !hash.UncompressedObjectIDs():
+			kept = append(kept, sha1)
+		default:
+			unreachable = append(unreachable, sha1)
+		}
+	}
+	return kept, unreachable
+}
+
+// explode writes packed unreachable objects out as loose objects, dated
+// like the pack they came from, before that pack is deleted.
+func explode(store *objects.RepositoryStore, sha1s [][]byte) error {
+	for _, sha1 := range sha1s {
+		p := store.Packs.Find(sha1)
+		if p == nil {
+			continue
+		}
+		reader, err := store.Packs.Open(sha1)
+		if err != nil {
+			return err
+		}
+		written, err := objects.WriteSha1StreamTo(store.Loose, reader.Type, reader.Size, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(written, sha1) {
+			return fmt.Errorf("%x: unpacked object hashes to %x", sha1, written)
+		}
+		stat, err := os.Stat(p.Name + ".pack")
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(store.Loose.FileName(sha1), stat.ModTime(), stat.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nameObjects gives every object its type and, where one is known, the path
+// it is stored under, taken from the trees being packed and the index. The
+// list is ordered commits first and blobs last, as Git lays out packs.
+func nameObjects(sha1s [][]byte) ([]*walk.Object, error) {
+	objectList := make([]*walk.Object, 0, len(sha1s))
+	bySha1 := map[string]*walk.Object{}
+	for _, sha1 := range sha1s {
+		objType, _, err := objects.ReadSha1Header(sha1)
+		if err != nil {
+			return nil, err
+		}
+		object := &walk.Object{Sha1: sha1, Type: objType}
+		objectList = append(objectList, object)
+		bySha1[string(sha1)] = object
+	}
+	for _, object := range objectList {
+		if object.Type != "tree" {
+			continue
+		}
+		tree, err := gitobject.ReadTree(object.Sha1)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range tree.Entries {
+			if child, ok := bySha1[string(entry.Sha1)]; ok && child.Path == "" {
+				child.Path = entry.Name
+			}
+		}
+	}
+	activeCache, err := cache.ReadCache()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range activeCache {
+		if object, ok := bySha1[string(entry.Sha1)]; ok && object.Path == "" {
+			object.Path = entry.Name
+		}
+	}
+	sort.SliceStable(objectList, func(i, j int) bool {
+		return typeOrder[objectList[i].Type] < typeOrder[objectList[j].Type]
+	})
+	return objectList, nil
+}
@@ -0,0 +1,249 @@
+package prune
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marutaku/go-git/internal/bitmap"
+	"github.com/marutaku/go-git/internal/cache"
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/objects"
+	"github.com/marutaku/go-git/internal/refs"
+	"github.com/marutaku/go-git/internal/walk"
+)
+
+var DEFAULT_EXPIRE = "336h"
+
+// Lock files older than STALE_LOCK_AGE are assumed to be left over by a
+// crashed command; no command holds a lock that long.
+var STALE_LOCK_AGE = time.Hour
+
+// ParseExpire turns "now", "never" or a Go duration such as "336h" into the
+// cut-off time. Objects last modified before it may be pruned. "never"
+// returns the zero time.
+func ParseExpire(value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "now":
+		return now, nil
+	case "never":
+		return time.Time{}, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry %q", value)
+	}
+	return now.Add(-duration), nil
+}
+
+// DefaultExpire reads gc.pruneexpire, falling back to two weeks.
+func DefaultExpire(now time.Time) (time.Time, error) {
+	repositoryConfig, err := config.Current()
+	if err != nil {
+		return time.Time{}, err
+	}
+	value := repositoryConfig.Get("gc.pruneexpire")
+	if value == "" {
+		value = DEFAULT_EXPIRE
+	}
+	return ParseExpire(value, now)
+}
+
+// Reachable returns every object reachable from refs, plus the blobs staged
+// in the index.
+func Reachable() (map[string]bool, error) {
+	var roots [][]byte
+	err := refs.ForEachRef(func(name string, sha1 []byte) error {
+		roots = append(roots, sha1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reachable := map[string]bool{}
+	err = reachableFrom(roots, func(sha1 []byte) error {
+		reachable[string(sha1)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	activeCache, err := cache.ReadCache()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range activeCache {
+		reachable[string(entry.Sha1)] = true
+	}
+	return reachable, nil
+}
+
+// reachableFrom uses the reachability bitmap of a pack when there is one and
+// walks the whole graph otherwise.
+func reachableFrom(roots [][]byte, fn func(sha1 []byte) error) error {
+	if store, ok := objects.GetStore().(*objects.RepositoryStore); ok {
+		if err := store.Packs.Reload(); err != nil {
+			return err
+		}
+		index, err := bitmap.Open(store.Packs.Packs())
+		if err != nil {
+			log.Printf("warning: ignoring bitmap: %v", err)
+		}
+		if index != nil {
+			return index.Reachable(roots, objects.ReadSha1File, fn)
+		}
+	}
+	return walk.Reachable(roots, func(object *walk.Object) error {
+		return fn(object.Sha1)
+	})
+}
+
+type Options struct {
+	// Expire is the cut-off modification time; the zero time keeps everything.
+	Expire time.Time
+	DryRun bool
+	// Report is called for every object that is (or would be) removed.
+	Report func(sha1 []byte, nodeType string)
+	// Reachable may be passed in when the caller already computed it.
+	Reachable map[string]bool
+}
+
+func expired(path string, expire time.Time) (bool, error) {
+	if expire.IsZero() {
+		return false, nil
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return !stat.ModTime().After(expire), nil
+}
+
+// Prune removes unreachable loose objects that have not been modified since
+// options.Expire. Packed objects are left to repack.
+func Prune(options *Options) error {
+	store, ok := objects.GetStore().(*objects.RepositoryStore)
+	if !ok {
+		return errors.New("prune needs a repository object store")
+	}
+	reachable := options.Reachable
+	if reachable == nil {
+		var err error
+		if reachable, err = Reachable(); err != nil {
+			return err
+		}
+	}
+	var candidates [][]byte
+	err := store.Loose.Iterate(func(sha1 []byte) error {
+		if !reachable[string(sha1)] {
+			candidates = append(candidates, sha1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, sha1 := range candidates {
+		isExpired, err := expired(objects.GetSha1FileName(sha1), options.Expire)
+		if err != nil {
+			return err
+		}
+		if !isExpired {
+			continue
+		}
+		if options.Report != nil {
+			reader, err := store.Loose.Open(sha1)
+			if err != nil {
+				return err
+			}
+			reader.Close()
+			options.Report(sha1, reader.Type)
+		}
+		if options.DryRun {
+			continue
+		}
+		if err := store.Loose.Delete(sha1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func staleFileCandidates() ([]string, []string, error) {
+	dir := env.GetSHA1FileDirectory()
+	locks := []string{
+		filepath.Join(dir, "index.lock"),
+		filepath.Join(dir, "config.lock"),
+	}
+	err := filepath.WalkDir(filepath.Join(dir, "refs"), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".lock") {
+			locks = append(locks, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var temporaries []string
+	for _, pattern := range []string{
+		filepath.Join(dir, "objects", "tmp_obj_*"),
+		filepath.Join(dir, "objects", "pack", "tmp_*"),
+	} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		temporaries = append(temporaries, matches...)
+	}
+	return locks, temporaries, nil
+}
+
+// CleanStaleFiles removes lock files older than STALE_LOCK_AGE and
+// temporary files left by interrupted writes that are older than expire.
+func CleanStaleFiles(expire time.Time, dryRun bool, report func(path string)) error {
+	locks, temporaries, err := staleFileCandidates()
+	if err != nil {
+		return err
+	}
+	remove := func(path string, cutoff time.Time) error {
+		isExpired, err := expired(path, cutoff)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !isExpired {
+			return nil
+		}
+		if report != nil {
+			report(path)
+		}
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	for _, path := range locks {
+		if err := remove(path, time.Now().Add(-STALE_LOCK_AGE)); err != nil {
+			return err
+		}
+	}
+	for _, path := range temporaries {
+		if err := remove(path, expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package refs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/config"
+	"github.com/marutaku/go-git/internal/durable"
+	"github.com/marutaku/go-git/internal/env"
+	"github.com/marutaku/go-git/internal/hash"
+)
+
+// GetRefFileName returns where a ref such as "refs/heads/master" is stored.
+func GetRefFileName(name string) string {
+	return filepath.Join(env.GetSHA1FileDirectory(), filepath.FromSlash(name))
+}
+
+// VerifyRefName accepts names below refs/ made of ordinary path components.
+func VerifyRefName(name string) error {
+	if !strings.HasPrefix(name, "refs/") {
+		return fmt.Errorf("ref name %q must start with refs/", name)
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component[0] == '.' || strings.HasSuffix(component, ".lock") {
+			return fmt.Errorf("invalid ref name %q", name)
+		}
+	}
+	if strings.ContainsAny(name, " ~^:?*[\\") || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid ref name %q", name)
+	}
+	return nil
+}
+
+func parseRef(contents []byte) ([]byte, error) {
+	sha1, err := hex.DecodeString(string(bytes.TrimSpace(contents)))
+	if err != nil || len(sha1) != hash.Size() {
+		return nil, errors.New("ref does not contain an object name")
+	}
+	return sha1, nil
+}
+
+func ReadRef(name string) ([]byte, error) {
+	contents, err := os.ReadFile(GetRefFileName(name))
+	if err != nil {
+		return nil, err
+	}
+	sha1, err := parseRef(contents)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return sha1, nil
+}
+
+// ForEachRef calls fn for every ref under refs/, plus HEAD when it holds an
+// object name directly. A symbolic HEAD is skipped: its target is a ref.
+func ForEachRef(fn func(name string, sha1 []byte) error) error {
+	head, err := os.ReadFile(GetRefFileName("HEAD"))
+	if err == nil && !bytes.HasPrefix(head, []byte("ref: ")) {
+		sha1, err := parseRef(head)
+		if err != nil {
+			return fmt.Errorf("HEAD: %w", err)
+		}
+		if err := fn("HEAD", sha1); err != nil {
+			return err
+		}
+	}
+	root := GetRefFileName("refs")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		relative, err := filepath.Rel(env.GetSHA1FileDirectory(), path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relative)
+		sha1, err := ReadRef(name)
+		if err != nil {
+			return err
+		}
+		return fn(name, sha1)
+	})
+	return err
+}
+
+// UpdateRef points name at sha1, writing through <ref>.lock so that readers
+// see either the old or the new value.
+func UpdateRef(name string, sha1 []byte) error {
+	if err := VerifyRefName(name); err != nil {
+		return err
+	}
+	fileName := GetRefFileName(name)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	lockFileName := fileName + ".lock"
+	file, err := os.OpenFile(lockFileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", name, err)
+	}
+	if _, err := fmt.Fprintf(file, "%x\n", sha1); err != nil {
+		file.Close()
+		os.Remove(lockFileName)
+		return err
+	}
+	return durable.CommitFile(file, fileName, config.FSYNC_REFERENCE)
+}
+
+func DeleteRef(name string) error {
+	if err := VerifyRefName(name); err != nil {
+		return err
+	}
+	return os.Remove(GetRefFileName(name))
+}
@@ -1,29 +1,114 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
+	"fmt"
 	"io"
+
+	"github.com/klauspost/compress/zstd"
 )
 
-// Compress compresses the contents using zlib
-func Compress(contents []byte) ([]byte, error) {
+const (
+	CODEC_ZLIB = "zlib"
+	CODEC_ZSTD = "zstd"
+)
+
+// ZSTD_MAGIC starts every zstd frame and tells zstd objects apart from zlib
+// streams, whose first byte is always 0x?8.
+var ZSTD_MAGIC = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Compression chooses the codec and level objects are compressed with.
+// Level follows zlib: -1 for the default, 0 to 9 from fastest to smallest.
+// zstd maps it onto its own speed settings.
+type Compression struct {
+	Codec string
+	Level int
+}
+
+var DefaultCompression = Compression{Codec: CODEC_ZLIB, Level: zlib.DefaultCompression}
+
+// COMPRESSION_PRESETS are the named settings offered by init-db and
+// compared by benchmark-compression.
+var COMPRESSION_PRESETS = []struct {
+	Name        string
+	Compression Compression
+}{
+	{"zlib", DefaultCompression},
+	{"zlib-fast", Compression{Codec: CODEC_ZLIB, Level: zlib.BestSpeed}},
+	{"zlib-best", Compression{Codec: CODEC_ZLIB, Level: zlib.BestCompression}},
+	{"zstd", Compression{Codec: CODEC_ZSTD, Level: zlib.DefaultCompression}},
+}
+
+func LookupCompressionPreset(name string) (Compression, bool) {
+	for _, preset := range COMPRESSION_PRESETS {
+		if preset.Name == name {
+			return preset.Compression, true
+		}
+	}
+	return Compression{}, false
+}
+
+func (c Compression) Validate() error {
+	if c.Codec != CODEC_ZLIB && c.Codec != CODEC_ZSTD {
+		return fmt.Errorf("unknown compression codec %q", c.Codec)
+	}
+	if c.Level < zlib.DefaultCompression || c.Level > zlib.BestCompression {
+		return fmt.Errorf("compression level %d out of range", c.Level)
+	}
+	return nil
+}
+
+func (c Compression) zstdLevel() zstd.EncoderLevel {
+	switch {
+	case c.Level == zlib.DefaultCompression:
+		return zstd.SpeedDefault
+	case c.Level <= 2:
+		return zstd.SpeedFastest
+	case c.Level <= 5:
+		return zstd.SpeedDefault
+	case c.Level <= 7:
+		return zstd.SpeedBetterCompression
+	}
+	return zstd.SpeedBestCompression
+}
+
+// NewWriter returns a writer that compresses everything written to it into w.
+// Close must be called to flush the stream.
+func (c Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Codec {
+	case CODEC_ZLIB:
+		return zlib.NewWriterLevel(w, c.Level)
+	case CODEC_ZSTD:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(c.zstdLevel()), zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", c.Codec)
+}
+
+func (c Compression) Compress(contents []byte) ([]byte, error) {
 	var compressed bytes.Buffer
-	writer := zlib.NewWriter(&compressed)
-	_, err := writer.Write(contents)
+	writer, err := c.NewWriter(&compressed)
 	if err != nil {
 		return nil, err
 	}
-	err = writer.Close()
-	if err != nil {
+	if _, err := writer.Write(contents); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
 		return nil, err
 	}
 	return compressed.Bytes(), nil
 }
 
+// Compress compresses the contents using zlib
+func Compress(contents []byte) ([]byte, error) {
+	return DefaultCompression.Compress(contents)
+}
+
 func Decompress(compressed []byte) ([]byte, error) {
 	byteReader := bytes.NewReader(compressed)
-	zr, err := zlib.NewReader(byteReader)
+	zr, err := NewDecompressReader(byteReader)
 	if err != nil {
 		return nil, err
 	}
@@ -35,3 +120,27 @@ func Decompress(compressed []byte) ([]byte, error) {
 	}
 	return writer.Bytes(), nil
 }
+
+// NewCompressWriter returns a writer that compresses everything written to it into w.
+// Close must be called to flush the stream.
+func NewCompressWriter(w io.Writer) io.WriteCloser {
+	return zlib.NewWriter(w)
+}
+
+// NewDecompressReader returns a reader that inflates the compressed stream r.
+// The codec is detected from the first bytes of the stream.
+func NewDecompressReader(r io.Reader) (io.ReadCloser, error) {
+	buffered, ok := r.(*bufio.Reader)
+	if !ok {
+		buffered = bufio.NewReader(r)
+	}
+	magic, _ := buffered.Peek(len(ZSTD_MAGIC))
+	if bytes.Equal(magic, ZSTD_MAGIC) {
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return zlib.NewReader(buffered)
+}
@@ -0,0 +1,267 @@
+package walk
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+
+	"github.com/marutaku/go-git/internal/commitgraph"
+	gitobject "github.com/marutaku/go-git/internal/object"
+)
+
+// Commit is what history walks need to know about a commit. It comes from
+// the commit-graph when the commit is in it and from the object otherwise;
+// Generation is commitgraph.GENERATION_UNKNOWN for commits outside the graph.
+type Commit struct {
+	Sha1       []byte
+	Tree       []byte
+	Parents    [][]byte
+	Time       int64
+	Generation uint32
+}
+
+// LookupCommit returns the commit sha1. Only commits outside the
+// commit-graph are inflated.
+func LookupCommit(sha1 []byte) (*Commit, error) {
+	if graph := commitgraph.Current(); graph != nil {
+		if pos, ok := graph.Find(sha1); ok {
+			return graphCommit(graph, pos)
+		}
+	}
+	commit, err := gitobject.ReadCommit(sha1)
+	if err != nil {
+		return nil, fmt.Errorf("commit %x: %w", sha1, err)
+	}
+	return &Commit{
+		Sha1:       sha1,
+		Tree:       commit.Tree,
+		Parents:    commit.Parents,
+		Time:       commit.Committer.When.Unix(),
+		Generation: commitgraph.GENERATION_UNKNOWN,
+	}, nil
+}
+
+func graphCommit(graph *commitgraph.Graph, pos int) (*Commit, error) {
+	record, err := graph.Commit(pos)
+	if err != nil {
+		return nil, fmt.Errorf("commit-graph: commit %x: %w", graph.Sha1(pos), err)
+	}
+	commit := &Commit{
+		Sha1:       graph.Sha1(pos),
+		Tree:       record.Tree,
+		Time:       record.Time,
+		Generation: record.Generation,
+	}
+	for _, parent := range record.Parents {
+		commit.Parents = append(commit.Parents, graph.Sha1(parent))
+	}
+	return commit, nil
+}
+
+// InCommitGraph reports whether sha1 is a commit the commit-graph knows, so
+// that it need not be looked up in the object store.
+func InCommitGraph(sha1 []byte) bool {
+	graph := commitgraph.Current()
+	if graph == nil {
+		return false
+	}
+	_, ok := graph.Find(sha1)
+	return ok
+}
+
+// commitQueue pops the commit with the highest generation first, then the
+// most recent. Commits outside the graph have the highest generation of all,
+// so they are never popped after one of their ancestors.
+type commitQueue []*Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].Generation != q[j].Generation {
+		return q[i].Generation > q[j].Generation
+	}
+	return q[i].Time > q[j].Time
+}
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(*Commit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	commit := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return commit
+}
+
+// dateQueue pops the most recent commit first, as log lists history.
+type dateQueue struct {
+	*commitQueue
+}
+
+func (q dateQueue) Less(i, j int) bool {
+	return (*q.commitQueue)[i].Time > (*q.commitQueue)[j].Time
+}
+
+// ErrStop can be returned by the callback of History to end the walk.
+var ErrStop = errors.New("stop walking")
+
+// History calls fn with every commit reachable from tips, most recent
+// commit date first.
+func History(tips [][]byte, fn func(commit *Commit) error) error {
+	queue := dateQueue{&commitQueue{}}
+	seen := map[string]bool{}
+	push := func(sha1 []byte) error {
+		if seen[string(sha1)] {
+			return nil
+		}
+		seen[string(sha1)] = true
+		commit, err := LookupCommit(sha1)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, commit)
+		return nil
+	}
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return err
+		}
+	}
+	for queue.Len() > 0 {
+		commit := heap.Pop(queue).(*Commit)
+		if err := fn(commit); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+			return err
+		}
+		for _, parent := range commit.Parents {
+			if err := push(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant. With a
+// commit-graph the walk stops at commits whose generation is already lower
+// than the ancestor's.
+func IsAncestor(ancestor []byte, descendant []byte) (bool, error) {
+	target, err := LookupCommit(ancestor)
+	if err != nil {
+		return false, err
+	}
+	minGeneration := uint32(0)
+	if target.Generation != commitgraph.GENERATION_UNKNOWN {
+		minGeneration = target.Generation
+	}
+	seen := map[string]bool{}
+	stack := [][]byte{descendant}
+	for len(stack) > 0 {
+		sha1 := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[string(sha1)] {
+			continue
+		}
+		seen[string(sha1)] = true
+		if string(sha1) == string(ancestor) {
+			return true, nil
+		}
+		commit, err := LookupCommit(sha1)
+		if err != nil {
+			return false, err
+		}
+		if commit.Generation < minGeneration {
+			continue
+		}
+		stack = append(stack, commit.Parents...)
+	}
+	return false, nil
+}
+
+const (
+	paintedOne = 1 << iota
+	paintedTwo
+	stale
+)
+
+// MergeBases returns the best common ancestors of one and two: those common
+// ancestors that are not ancestors of another common ancestor.
+func MergeBases(one []byte, two []byte) ([][]byte, error) {
+	if string(one) == string(two) {
+		return [][]byte{one}, nil
+	}
+	flags := map[string]int{}
+	queue := &commitQueue{}
+	paint := func(sha1 []byte, flag int) error {
+		if flags[string(sha1)]&flag == flag {
+			return nil
+		}
+		// 既に見たコミットでもフラグが増えたら入れ直して親へ伝える
+		flags[string(sha1)] |= flag
+		commit, err := LookupCommit(sha1)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, commit)
+		return nil
+	}
+	if err := paint(one, paintedOne); err != nil {
+		return nil, err
+	}
+	if err := paint(two, paintedTwo); err != nil {
+		return nil, err
+	}
+	var candidates [][]byte
+	// キューが全てstaleになったら、それ以上共通の祖先は見つからない
+	for queue.Len() > 0 {
+		allStale := true
+		for _, commit := range *queue {
+			if flags[string(commit.Sha1)]&stale == 0 {
+				allStale = false
+				break
+			}
+		}
+		if allStale {
+			break
+		}
+		commit := heap.Pop(queue).(*Commit)
+		flag := flags[string(commit.Sha1)] & (paintedOne | paintedTwo | stale)
+		if flag&(paintedOne|paintedTwo) == paintedOne|paintedTwo {
+			if flag&stale == 0 {
+				candidates = append(candidates, commit.Sha1)
+				flag |= stale
+				flags[string(commit.Sha1)] |= stale
+			}
+		}
+		for _, parent := range commit.Parents {
+			if err := paint(parent, flag); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return removeRedundant(candidates)
+}
+
+// removeRedundant drops candidates that are ancestors of another candidate.
+func removeRedundant(candidates [][]byte) ([][]byte, error) {
+	var result [][]byte
+	for i, candidate := range candidates {
+		redundant := false
+		for j, other := range candidates {
+			if i == j {
+				continue
+			}
+			ancestor, err := IsAncestor(candidate, other)
+			if err != nil {
+				return nil, err
+			}
+			if ancestor {
+				redundant = true
+				break
+			}
+		}
+		if !redundant {
+			result = append(result, candidate)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,277 @@
+package walk
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/marutaku/go-git/internal/commitgraph"
+	gitobject "github.com/marutaku/go-git/internal/object"
+)
+
+func readTreeOrEmpty(sha1 []byte) (*gitobject.Tree, error) {
+	if sha1 == nil {
+		return &gitobject.Tree{}, nil
+	}
+	return gitobject.ReadTree(sha1)
+}
+
+// ChangedPaths returns the files that differ between two trees, recursing
+// only into subtrees whose IDs differ. A nil tree stands for the empty tree.
+// Directories are not listed; NewBloomFilter adds the leading directories
+// of every path, as Git does.
+func ChangedPaths(oldTree []byte, newTree []byte) ([]string, error) {
+	var changed []string
+	var diff func(oldSha1 []byte, newSha1 []byte, prefix string) error
+	diff = func(oldSha1 []byte, newSha1 []byte, prefix string) error {
+		oldEntries, err := readTreeOrEmpty(oldSha1)
+		if err != nil {
+			return err
+		}
+		newEntries, err := readTreeOrEmpty(newSha1)
+		if err != nil {
+			return err
+		}
+		byName := map[string]*gitobject.TreeEntry{}
+		for _, entry := range oldEntries.Entries {
+			byName[entry.Name] = entry
+		}
+		visit := func(oldEntry *gitobject.TreeEntry, newEntry *gitobject.TreeEntry) error {
+			var name string
+			if newEntry != nil {
+				name = newEntry.Name
+			} else {
+				name = oldEntry.Name
+			}
+			full := path.Join(prefix, name)
+			if oldEntry != nil && newEntry != nil && oldEntry.Mode == newEntry.Mode && bytes.Equal(oldEntry.Sha1, newEntry.Sha1) {
+				return nil
+			}
+			// ツリーは中のファイルを変更として数える
+			var oldSub, newSub []byte
+			if oldEntry != nil && oldEntry.IsTree() {
+				oldSub = oldEntry.Sha1
+			}
+			if newEntry != nil && newEntry.IsTree() {
+				newSub = newEntry.Sha1
+			}
+			if oldSub != nil || newSub != nil {
+				if err := diff(oldSub, newSub, full); err != nil {
+					return err
+				}
+			}
+			if (oldEntry != nil && !oldEntry.IsTree()) || (newEntry != nil && !newEntry.IsTree()) {
+				changed = append(changed, full)
+			}
+			return nil
+		}
+		for _, entry := range newEntries.Entries {
+			oldEntry := byName[entry.Name]
+			delete(byName, entry.Name)
+			if err := visit(oldEntry, entry); err != nil {
+				return err
+			}
+		}
+		for _, entry := range oldEntries.Entries {
+			if _, ok := byName[entry.Name]; ok {
+				if err := visit(entry, nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := diff(oldTree, newTree, ""); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// LookupPath returns the entry stored at name below tree, or nil when there
+// is none. write-tree makes flat trees whose entry names are full paths, so
+// the whole remaining path is matched against the names before walking down
+// into a subtree.
+func LookupPath(tree []byte, name string) (*gitobject.TreeEntry, error) {
+	rest := strings.Trim(name, "/")
+	for {
+		entries, err := gitobject.ReadTree(tree)
+		if err != nil {
+			return nil, err
+		}
+		var subtree *gitobject.TreeEntry
+		for _, entry := range entries.Entries {
+			if entry.Name == rest {
+				return entry, nil
+			}
+			if entry.IsTree() && strings.HasPrefix(rest, entry.Name+"/") && (subtree == nil || len(entry.Name) > len(subtree.Name)) {
+				subtree = entry
+			}
+		}
+		if subtree == nil {
+			return nil, nil
+		}
+		rest = rest[len(subtree.Name)+1:]
+		tree = subtree.Sha1
+	}
+}
+
+// pathEntries returns what tree has at name: the entry itself, or for a
+// directory of a flat tree, every entry whose name starts with "name/".
+// Names of the returned entries are relative to the tree they came from.
+func pathEntries(tree []byte, name string) ([]*gitobject.TreeEntry, error) {
+	if tree == nil {
+		return nil, nil
+	}
+	rest := strings.Trim(name, "/")
+	entries, err := gitobject.ReadTree(tree)
+	if err != nil {
+		return nil, err
+	}
+	var found []*gitobject.TreeEntry
+	for _, entry := range entries.Entries {
+		switch {
+		case entry.Name == rest || strings.HasPrefix(entry.Name, rest+"/"):
+			found = append(found, entry)
+		case entry.IsTree() && strings.HasPrefix(rest, entry.Name+"/"):
+			below, err := pathEntries(entry.Sha1, rest[len(entry.Name)+1:])
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, below...)
+		}
+	}
+	return found, nil
+}
+
+func sameEntries(a []*gitobject.TreeEntry, b []*gitobject.TreeEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Mode != b[i].Mode || !bytes.Equal(a[i].Sha1, b[i].Sha1) {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomFilter returns the changed-path filter the commit-graph keeps for
+// commit, or nil.
+func BloomFilter(commit *Commit) *commitgraph.BloomFilter {
+	graph := commitgraph.Current()
+	if graph == nil {
+		return nil
+	}
+	pos, ok := graph.Find(commit.Sha1)
+	if !ok {
+		return nil
+	}
+	return graph.BloomFilter(pos)
+}
+
+// SamePaths reports whether paths are the same in commit and its parent.
+// For the first parent the commit's Bloom filter can answer without diffing
+// trees when none of the paths were touched.
+func SamePaths(commit *Commit, parentIndex int, paths []string) (bool, error) {
+	if parentIndex == 0 {
+		if filter := BloomFilter(commit); filter != nil {
+			touched := false
+			for _, name := range paths {
+				if filter.MaybeContains(name) {
+					touched = true
+					break
+				}
+			}
+			if !touched {
+				return true, nil
+			}
+		}
+	}
+	var parentTree []byte
+	if parentIndex < len(commit.Parents) {
+		parent, err := LookupCommit(commit.Parents[parentIndex])
+		if err != nil {
+			return false, err
+		}
+		parentTree = parent.Tree
+	}
+	for _, name := range paths {
+		entries, err := pathEntries(commit.Tree, name)
+		if err != nil {
+			return false, err
+		}
+		parentEntries, err := pathEntries(parentTree, name)
+		if err != nil {
+			return false, err
+		}
+		if !sameEntries(entries, parentEntries) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// PathHistory calls fn, most recent first, with the commits reachable from
+// tips that changed one of paths. Like Git's default history
+// simplification, a commit that leaves the paths as one of its parents had
+// them is skipped and only that parent is followed.
+func PathHistory(tips [][]byte, paths []string, fn func(commit *Commit) error) error {
+	queue := dateQueue{&commitQueue{}}
+	seen := map[string]bool{}
+	push := func(sha1 []byte) error {
+		if seen[string(sha1)] {
+			return nil
+		}
+		seen[string(sha1)] = true
+		commit, err := LookupCommit(sha1)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, commit)
+		return nil
+	}
+	for _, tip := range tips {
+		if err := push(tip); err != nil {
+			return err
+		}
+	}
+	for queue.Len() > 0 {
+		commit := heap.Pop(queue).(*Commit)
+		parents := commit.Parents
+		show := true
+		if len(parents) == 0 {
+			same, err := SamePaths(commit, 0, paths)
+			if err != nil {
+				return err
+			}
+			show = !same
+		}
+		for i, parent := range commit.Parents {
+			same, err := SamePaths(commit, i, paths)
+			if err != nil {
+				return err
+			}
+			if same {
+				parents = [][]byte{parent}
+				show = false
+				break
+			}
+		}
+		if show {
+			if err := fn(commit); err != nil {
+				if errors.Is(err, ErrStop) {
+					return nil
+				}
+				return err
+			}
+		}
+		for _, parent := range parents {
+			if err := push(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package walk
+
+import (
+	"fmt"
+	"path"
+
+	gitobject "github.com/marutaku/go-git/internal/object"
+	"github.com/marutaku/go-git/internal/objects"
+)
+
+// Object is an object found while walking, together with the path it was
+// first reached under. Commits have no path.
+type Object struct {
+	Sha1 []byte
+	Type string
+	Path string
+}
+
+// Reachable calls fn once for every object reachable from roots: tags and
+// what they point at, commits, their trees and everything those trees
+// contain. Blobs are only checked for existence, never inflated.
+func Reachable(roots [][]byte, fn func(object *Object) error) error {
+	seen := map[string]bool{}
+	// 長い履歴で再帰が深くならないようにスタックで辿る
+	var stack []*Object
+	for i := len(roots) - 1; i >= 0; i-- {
+		stack = append(stack, &Object{Sha1: roots[i]})
+	}
+	for len(stack) > 0 {
+		object := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[string(object.Sha1)] {
+			continue
+		}
+		seen[string(object.Sha1)] = true
+		if object.Type == "blob" {
+			if !objects.HasSha1File(object.Sha1) {
+				return fmt.Errorf("%x: %w", object.Sha1, objects.ErrObjectNotFound)
+			}
+			if err := fn(object); err != nil {
+				return err
+			}
+			continue
+		}
+		// commit-graphにあるコミットは展開せずに親とツリーが分かる。ただし消えた
+		// コミットが残っていることもあるので、無ければ下で欠損として報告する
+		if object.Type != "tree" && InCommitGraph(object.Sha1) && objects.HasSha1File(object.Sha1) {
+			commit, err := LookupCommit(object.Sha1)
+			if err != nil {
+				return err
+			}
+			object.Type = "commit"
+			if err := fn(object); err != nil {
+				return err
+			}
+			for i := len(commit.Parents) - 1; i >= 0; i-- {
+				stack = append(stack, &Object{Sha1: commit.Parents[i]})
+			}
+			stack = append(stack, &Object{Sha1: commit.Tree})
+			continue
+		}
+		nodeType, body, err := objects.ReadSha1File(object.Sha1)
+		if err != nil {
+			return err
+		}
+		object.Type = nodeType
+		if err := fn(object); err != nil {
+			return err
+		}
+		var next []*Object
+		switch nodeType {
+		case "commit":
+			commit, err := gitobject.ParseCommit(body)
+			if err != nil {
+				return fmt.Errorf("commit %x: %w", object.Sha1, err)
+			}
+			next = append(next, &Object{Sha1: commit.Tree})
+			for _, parent := range commit.Parents {
+				next = append(next, &Object{Sha1: parent})
+			}
+		case "tag":
+			tag, err := gitobject.ParseTag(body)
+			if err != nil {
+				return fmt.Errorf("tag %x: %w", object.Sha1, err)
+			}
+			child := &Object{Sha1: tag.Object}
+			if tag.TargetType == "blob" {
+				child.Type = "blob"
+			}
+			next = append(next, child)
+		case "tree":
+			tree, err := gitobject.ParseTree(body)
+			if err != nil {
+				return fmt.Errorf("tree %x: %w", object.Sha1, err)
+			}
+			for _, entry := range tree.Entries {
+				if entry.IsGitlink() {
+					continue
+				}
+				child := &Object{Sha1: entry.Sha1, Path: path.Join(object.Path, entry.Name)}
+				if !entry.IsTree() {
+					child.Type = "blob"
+				}
+				next = append(next, child)
+			}
+		}
+		for i := len(next) - 1; i >= 0; i-- {
+			stack = append(stack, next[i])
+		}
+	}
+	return nil
+}